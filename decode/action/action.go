@@ -1,33 +1,64 @@
 package action
 
 import (
+	"bytes"
+
 	"github.com/badvassal/wllib/gen"
 )
 
 // These constants are table indices.  The action table with a given index
-// contains the specified type of data.
+// contains the specified type of data.  See
+// <https://wasteland.gamepedia.com/Map_Tile_Action_Classes>.
+// XXX: Only the loot and transition layouts predate the other types.  The
+// remaining typed layouts (including shops) are provisional; elements that
+// don't fit them are retained raw (see the Raw field of each type).
 const (
+	IDPrint      = 1
+	IDCheck      = 2
+	IDPassword   = 3
+	IDAlteration = 4
 	IDLoot       = 5
 	IDShop       = 6
+	IDImpassable = 7
 	IDTransition = 10
+	IDDialogue   = 11
+	IDEncounter  = 13
 )
 
-// Tables is the set of action tables in a single MSQ block.
+// listTerminator ends the variable-length lists inside action elements.
+const listTerminator = 0xff
+
+// needsRaw indicates whether a table element must be retained as raw bytes.
+// This is the case if it failed to decode, if decoding didn't consume the
+// whole element, or if re-encoding the decoded form doesn't reproduce the
+// element exactly.  n and err are the results of decoding elem; encode
+// re-encodes the decoded form (only called if decoding succeeded).
+func needsRaw(elem []byte, n int, err error, encode func() []byte) bool {
+	if err != nil || n != len(elem) {
+		return true
+	}
+
+	return !bytes.Equal(encode(), elem)
+}
+
+// Tables is the set of action tables in a single MSQ block.  No layout is
+// known for tables 0, 8, 9, 12, 14, and 15, so they are kept as generic tables
+// and written back unchanged.
 type Tables struct {
 	T0          gen.Table
-	T1          gen.Table
-	T2          gen.Table
-	T3          gen.Table
-	T4          gen.Table
-	Loots       []*Loot // Table 5
-	T6          gen.Table
-	T7          gen.Table
+	Prints      []*Print      // Table 1.
+	Checks      []*Check      // Table 2.
+	Passwords   []*Password   // Table 3.
+	Alterations []*Alteration // Table 4.
+	Loots       []*Loot       // Table 5.
+	Shops       []*Shop       // Table 6.
+	Impassables []*Impassable // Table 7.
 	T8          gen.Table
 	T9          gen.Table
 	Transitions []*Transition // Table 10.
-	T11         gen.Table
+	Dialogues   []*Dialogue   // Table 11.
 	T12         gen.Table
-	T13         gen.Table
+	Encounters  []*Encounter // Table 13.
 	T14         gen.Table
 	T15         gen.Table
 }
@@ -0,0 +1,205 @@
+package action
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/badvassal/wllib/gen"
+)
+
+func TestTypedTableRawFallback(t *testing.T) {
+	good := []byte{10, 2, 3, 4, 0xff}
+	bad := []byte{10, 2, 3} // No terminator.
+
+	table := gen.Table{Elems: [][]byte{good, nil, bad}}
+
+	ps := DecodePrintTable(table)
+	if len(ps) != 3 || ps[1] != nil {
+		t.Fatalf("wrong table shape: %+v", ps)
+	}
+
+	if ps[0].Raw != nil || ps[0].ToClass != 10 ||
+		len(ps[0].StringIDs) != 2 {
+
+		t.Fatalf("element not decoded: %+v", *ps[0])
+	}
+	if !bytes.Equal(ps[2].Raw, bad) {
+		t.Fatalf("undecodable element not retained raw: %+v", *ps[2])
+	}
+
+	for i, p := range []*Print{ps[0], ps[2]} {
+		want := table.Elems[i*2]
+		if have := EncodePrint(*p); !bytes.Equal(have, want) {
+			t.Errorf("element %d changed: have=%x want=%x", i*2, have, want)
+		}
+	}
+}
+
+func TestCheckRoundTrip(t *testing.T) {
+	elem := []byte{
+		0, 1, 2, 3, 5, 4, 0, 0,
+		CheckTypeSkill, 0x0f, 10,
+		CheckTypeAttribute, 0x00, 12,
+		0xff,
+	}
+
+	cs := DecodeCheckTable(gen.Table{Elems: [][]byte{elem}})
+	c := cs[0]
+	if c.Raw != nil || len(c.Elems) != 2 {
+		t.Fatalf("check not decoded: %+v", *c)
+	}
+	if c.Elems[0].Name() != "Picklock" || c.Elems[1].Name() != "Strength" {
+		t.Fatalf("wrong check names: %s, %s",
+			c.Elems[0].Name(), c.Elems[1].Name())
+	}
+	if c.PassClass != 5 || c.PassSelector != 4 {
+		t.Fatalf("wrong pass target: %+v", *c)
+	}
+
+	if have := EncodeCheck(*c); !bytes.Equal(have, elem) {
+		t.Fatalf("check changed: have=%x want=%x", have, elem)
+	}
+}
+
+func TestShopRoundTrip(t *testing.T) {
+	elem := []byte{
+		0x1c, 3, 0x10, 0x27,
+		0x05, 1, 0x64, 0x00,
+		0xff,
+	}
+
+	shops := DecodeShopTable(gen.Table{Elems: [][]byte{elem}})
+	s := shops[0]
+	if s.Raw != nil || len(s.Items) != 2 {
+		t.Fatalf("shop not decoded: %+v", *s)
+	}
+	if s.Items[0] != (ShopItem{ID: 0x1c, Stock: 3, Price: 10000}) {
+		t.Fatalf("wrong shop item: %+v", s.Items[0])
+	}
+
+	if have := EncodeShop(*s); !bytes.Equal(have, elem) {
+		t.Fatalf("shop changed: have=%x want=%x", have, elem)
+	}
+}
+
+func TestShopMalformed(t *testing.T) {
+	truncated := []byte{0x1c, 3, 0x10} // Partial item, no terminator.
+	trailing := []byte{0x1c, 3, 0x10, 0x27, 0xff, 0x01}
+
+	shops := DecodeShopTable(gen.Table{Elems: [][]byte{truncated, trailing}})
+	for i, s := range shops {
+		want := [][]byte{truncated, trailing}[i]
+		if !bytes.Equal(s.Raw, want) {
+			t.Errorf("malformed shop %d not retained raw: %+v", i, *s)
+		}
+		if have := EncodeShop(*s); !bytes.Equal(have, want) {
+			t.Errorf("shop %d changed: have=%x want=%x", i, have, want)
+		}
+	}
+}
+
+func TestPasswordRoundTrip(t *testing.T) {
+	elem := []byte{7, 10, 2, 10, 3, 'W', 'I', 'Z', 'Z', 'A', 'R', 'D', 0}
+
+	ps := DecodePasswordTable(gen.Table{Elems: [][]byte{elem}})
+	p := ps[0]
+	if p.Raw != nil || p.Word != "WIZZARD" || p.PromptStringID != 7 ||
+		p.PassSelector != 2 || p.FailSelector != 3 {
+
+		t.Fatalf("password not decoded: %+v", *p)
+	}
+
+	if have := EncodePassword(*p); !bytes.Equal(have, elem) {
+		t.Fatalf("password changed: have=%x want=%x", have, elem)
+	}
+}
+
+func TestAlterationRoundTrip(t *testing.T) {
+	elem := []byte{
+		5, 1,
+		3, 4, 0, 0,
+		7, 2, 10, 1,
+		0xff,
+	}
+
+	as := DecodeAlterationTable(gen.Table{Elems: [][]byte{elem}})
+	a := as[0]
+	if a.Raw != nil || len(a.Elems) != 2 || a.ToClass != 5 {
+		t.Fatalf("alteration not decoded: %+v", *a)
+	}
+	if a.Elems[1] != (AlterationElem{X: 7, Y: 2, Class: 10, Selector: 1}) {
+		t.Fatalf("wrong alteration element: %+v", a.Elems[1])
+	}
+
+	if have := EncodeAlteration(*a); !bytes.Equal(have, elem) {
+		t.Fatalf("alteration changed: have=%x want=%x", have, elem)
+	}
+}
+
+func TestImpassableRoundTrip(t *testing.T) {
+	elem := []byte{0x0c}
+	long := []byte{0x0c, 0x0d} // Unknown extra byte.
+
+	is := DecodeImpassableTable(gen.Table{Elems: [][]byte{elem, long}})
+	if is[0].Raw != nil || is[0].StringID != 0x0c {
+		t.Fatalf("impassable not decoded: %+v", *is[0])
+	}
+	if !bytes.Equal(is[1].Raw, long) {
+		t.Fatalf("oversized impassable not retained raw: %+v", *is[1])
+	}
+
+	for i, want := range [][]byte{elem, long} {
+		if have := EncodeImpassable(*is[i]); !bytes.Equal(have, want) {
+			t.Errorf("impassable %d changed: have=%x want=%x", i, have, want)
+		}
+	}
+}
+
+func TestDialogueRoundTrip(t *testing.T) {
+	elem := []byte{
+		4,
+		5, 10, 1,
+		6, 0xff, 0,
+		0xff,
+	}
+
+	ds := DecodeDialogueTable(gen.Table{Elems: [][]byte{elem}})
+	d := ds[0]
+	if d.Raw != nil || d.PromptStringID != 4 || len(d.Options) != 2 {
+		t.Fatalf("dialogue not decoded: %+v", *d)
+	}
+	if d.Options[1] != (DialogueOption{StringID: 6, ToClass: 0xff}) {
+		t.Fatalf("wrong dialogue option: %+v", d.Options[1])
+	}
+
+	if have := EncodeDialogue(*d); !bytes.Equal(have, elem) {
+		t.Fatalf("dialogue changed: have=%x want=%x", have, elem)
+	}
+}
+
+func TestEncounterRoundTrip(t *testing.T) {
+	elem := []byte{0x83, 2, 4, 10, 1}
+	short := []byte{0x03, 2, 4}
+
+	es := DecodeEncounterTable(gen.Table{Elems: [][]byte{elem, nil, short}})
+	if len(es) != 3 || es[1] != nil {
+		t.Fatalf("wrong table shape: %+v", es)
+	}
+
+	e := es[0]
+	if e.Raw != nil || !e.Fixed || e.MonsterIdx != 3 || e.MinCount != 2 ||
+		e.MaxCount != 4 || e.ToClass != 10 || e.ToSelector != 1 {
+
+		t.Fatalf("encounter not decoded: %+v", *e)
+	}
+	if !bytes.Equal(es[2].Raw, short) {
+		t.Fatalf("truncated encounter not retained raw: %+v", *es[2])
+	}
+
+	for _, i := range []int{0, 2} {
+		want := [][]byte{elem, nil, short}[i]
+		if have := EncodeEncounter(*es[i]); !bytes.Equal(have, want) {
+			t.Errorf("encounter %d changed: have=%x want=%x", i, have, want)
+		}
+	}
+}
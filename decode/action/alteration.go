@@ -0,0 +1,113 @@
+package action
+
+import (
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+const alterationElemLen = 4
+
+// AlterationElem changes the action of a single tile.
+type AlterationElem struct {
+	X        int // B0
+	Y        int // B1
+	Class    int // B2
+	Selector int // B3
+}
+
+// Alteration represents a tile alteration action in an MSQ block.  It
+// changes the actions of other tiles on the map, e.g., to open a passage.
+type Alteration struct {
+	ToClass    int // B0
+	ToSelector int // B1
+	Elems      []AlterationElem
+
+	Raw []byte // Set if the element could not be decoded.
+}
+
+// DecodeAlteration decodes an alteration action from a sequence of bytes.  It
+// returns the decoded action and its length (in bytes).
+func DecodeAlteration(data []byte) (*Alteration, int, error) {
+	wrapErr := wlerr.MakeWrapper("failed to decode alteration action")
+
+	if len(data) < 3 {
+		return nil, 0, wrapErr(nil,
+			"data length too short: have=%d want>=3", len(data))
+	}
+
+	a := &Alteration{
+		ToClass:    int(data[0]),
+		ToSelector: int(data[1]),
+	}
+	off := 2
+
+	for {
+		if off >= len(data) {
+			return nil, 0, wrapErr(nil,
+				"alteration list missing terminator byte")
+		}
+
+		if data[off] == listTerminator {
+			off++
+			break
+		}
+
+		if len(data)-off < alterationElemLen {
+			return nil, 0, wrapErr(nil,
+				"alteration element truncated: have=%d want>=%d",
+				len(data)-off, alterationElemLen)
+		}
+
+		a.Elems = append(a.Elems, AlterationElem{
+			X:        int(data[off]),
+			Y:        int(data[off+1]),
+			Class:    int(data[off+2]),
+			Selector: int(data[off+3]),
+		})
+		off += alterationElemLen
+	}
+
+	return a, off, nil
+}
+
+// DecodeAlterationTable decodes a set of alteration actions from a table of
+// byte buffers.  Elements that cannot be decoded losslessly are retained raw.
+func DecodeAlterationTable(table gen.Table) []*Alteration {
+	var as []*Alteration
+
+	for _, elem := range table.Elems {
+		if len(elem) == 0 {
+			as = append(as, nil)
+			continue
+		}
+
+		a, n, err := DecodeAlteration(elem)
+		if needsRaw(elem, n, err,
+			func() []byte { return EncodeAlteration(*a) }) {
+
+			a = &Alteration{Raw: elem}
+		}
+		as = append(as, a)
+	}
+
+	return as
+}
+
+// EncodeAlteration encodes an alteration action to a byte sequence.
+func EncodeAlteration(a Alteration) []byte {
+	if a.Raw != nil {
+		return a.Raw
+	}
+
+	var b []byte
+
+	b = append(b, byte(a.ToClass))
+	b = append(b, byte(a.ToSelector))
+	for _, e := range a.Elems {
+		b = append(b,
+			byte(e.X), byte(e.Y), byte(e.Class), byte(e.Selector))
+	}
+	b = append(b, listTerminator)
+
+	return b
+}
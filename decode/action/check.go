@@ -0,0 +1,160 @@
+package action
+
+import (
+	"fmt"
+
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+const (
+	checkHdrLen  = 8
+	checkElemLen = 3
+)
+
+// These constants identify what a check element tests.
+const (
+	CheckTypeSkill     = 0
+	CheckTypeAttribute = 1
+	CheckTypeItem      = 2
+)
+
+// CheckElem is a single test performed by a check action.
+type CheckElem struct {
+	Type       int // B0 (CheckType*)
+	ID         int // B1 (skill, attribute, or item ID, depending on Type)
+	Difficulty int // B2
+}
+
+// Check represents a skill, attribute, or item check in an MSQ block.  If
+// any of its tests succeeds, the tile's action changes to the pass target;
+// otherwise it changes to the fail target.
+type Check struct {
+	Flags         int // B0
+	StartStringID int // B1
+	PassStringID  int // B2
+	FailStringID  int // B3
+	PassClass     int // B4
+	PassSelector  int // B5
+	FailClass     int // B6
+	FailSelector  int // B7
+	Elems         []CheckElem
+
+	Raw []byte // Set if the element could not be decoded.
+}
+
+// Name retrieves the name of the skill, attribute, or item a check element
+// tests.
+func (ce *CheckElem) Name() string {
+	switch ce.Type {
+	case CheckTypeSkill:
+		return defs.SkillString(ce.ID)
+	case CheckTypeAttribute:
+		return defs.AttributeString(ce.ID)
+	case CheckTypeItem:
+		return defs.ItemString(ce.ID)
+	default:
+		return "???"
+	}
+}
+
+// String produces a user friendly description of a check element.
+func (ce CheckElem) String() string {
+	return fmt.Sprintf("%s (difficulty %d)", ce.Name(), ce.Difficulty)
+}
+
+// DecodeCheck decodes a check action from a sequence of bytes.  It returns
+// the decoded action and its length (in bytes).
+func DecodeCheck(data []byte) (*Check, int, error) {
+	wrapErr := wlerr.MakeWrapper("failed to decode check action")
+
+	if len(data) < checkHdrLen+1 {
+		return nil, 0, wrapErr(nil,
+			"data length too short: have=%d want>=%d",
+			len(data), checkHdrLen+1)
+	}
+
+	c := &Check{
+		Flags:         int(data[0]),
+		StartStringID: int(data[1]),
+		PassStringID:  int(data[2]),
+		FailStringID:  int(data[3]),
+		PassClass:     int(data[4]),
+		PassSelector:  int(data[5]),
+		FailClass:     int(data[6]),
+		FailSelector:  int(data[7]),
+	}
+	off := checkHdrLen
+
+	for {
+		if off >= len(data) {
+			return nil, 0, wrapErr(nil, "check list missing terminator byte")
+		}
+
+		if data[off] == listTerminator {
+			off++
+			break
+		}
+
+		if len(data)-off < checkElemLen {
+			return nil, 0, wrapErr(nil,
+				"check element truncated: have=%d want>=%d",
+				len(data)-off, checkElemLen)
+		}
+
+		c.Elems = append(c.Elems, CheckElem{
+			Type:       int(data[off]),
+			ID:         int(data[off+1]),
+			Difficulty: int(data[off+2]),
+		})
+		off += checkElemLen
+	}
+
+	return c, off, nil
+}
+
+// DecodeCheckTable decodes a set of check actions from a table of byte
+// buffers.  Elements that cannot be decoded losslessly are retained raw.
+func DecodeCheckTable(table gen.Table) []*Check {
+	var cs []*Check
+
+	for _, elem := range table.Elems {
+		if len(elem) == 0 {
+			cs = append(cs, nil)
+			continue
+		}
+
+		c, n, err := DecodeCheck(elem)
+		if needsRaw(elem, n, err, func() []byte { return EncodeCheck(*c) }) {
+			c = &Check{Raw: elem}
+		}
+		cs = append(cs, c)
+	}
+
+	return cs
+}
+
+// EncodeCheck encodes a check action to a byte sequence.
+func EncodeCheck(c Check) []byte {
+	if c.Raw != nil {
+		return c.Raw
+	}
+
+	b := []byte{
+		byte(c.Flags),
+		byte(c.StartStringID),
+		byte(c.PassStringID),
+		byte(c.FailStringID),
+		byte(c.PassClass),
+		byte(c.PassSelector),
+		byte(c.FailClass),
+		byte(c.FailSelector),
+	}
+	for _, e := range c.Elems {
+		b = append(b, byte(e.Type), byte(e.ID), byte(e.Difficulty))
+	}
+	b = append(b, listTerminator)
+
+	return b
+}
@@ -0,0 +1,102 @@
+package action
+
+import (
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+const dialogueOptionLen = 3
+
+// DialogueOption is a single response the party can give in a dialogue.
+type DialogueOption struct {
+	StringID   int // B0
+	ToClass    int // B1
+	ToSelector int // B2
+}
+
+// Dialogue represents a conversation action in an MSQ block.  A prompt is
+// printed and the party's choice selects the tile's next action.
+type Dialogue struct {
+	PromptStringID int // B0
+	Options        []DialogueOption
+
+	Raw []byte // Set if the element could not be decoded.
+}
+
+// DecodeDialogue decodes a dialogue action from a sequence of bytes.  It
+// returns the decoded action and its length (in bytes).
+func DecodeDialogue(data []byte) (*Dialogue, int, error) {
+	wrapErr := wlerr.MakeWrapper("failed to decode dialogue action")
+
+	if len(data) < 2 {
+		return nil, 0, wrapErr(nil,
+			"data length too short: have=%d want>=2", len(data))
+	}
+
+	d := &Dialogue{
+		PromptStringID: int(data[0]),
+	}
+	off := 1
+
+	for {
+		if off >= len(data) {
+			return nil, 0, wrapErr(nil, "option list missing terminator byte")
+		}
+
+		if data[off] == listTerminator {
+			off++
+			break
+		}
+
+		if len(data)-off < dialogueOptionLen {
+			return nil, 0, wrapErr(nil,
+				"dialogue option truncated: have=%d want>=%d",
+				len(data)-off, dialogueOptionLen)
+		}
+
+		d.Options = append(d.Options, DialogueOption{
+			StringID:   int(data[off]),
+			ToClass:    int(data[off+1]),
+			ToSelector: int(data[off+2]),
+		})
+		off += dialogueOptionLen
+	}
+
+	return d, off, nil
+}
+
+// DecodeDialogueTable decodes a set of dialogue actions from a table of byte
+// buffers.  Elements that cannot be decoded losslessly are retained raw.
+func DecodeDialogueTable(table gen.Table) []*Dialogue {
+	var ds []*Dialogue
+
+	for _, elem := range table.Elems {
+		if len(elem) == 0 {
+			ds = append(ds, nil)
+			continue
+		}
+
+		d, n, err := DecodeDialogue(elem)
+		if needsRaw(elem, n, err, func() []byte { return EncodeDialogue(*d) }) {
+			d = &Dialogue{Raw: elem}
+		}
+		ds = append(ds, d)
+	}
+
+	return ds
+}
+
+// EncodeDialogue encodes a dialogue action to a byte sequence.
+func EncodeDialogue(d Dialogue) []byte {
+	if d.Raw != nil {
+		return d.Raw
+	}
+
+	b := []byte{byte(d.PromptStringID)}
+	for _, o := range d.Options {
+		b = append(b, byte(o.StringID), byte(o.ToClass), byte(o.ToSelector))
+	}
+	b = append(b, listTerminator)
+
+	return b
+}
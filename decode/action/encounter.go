@@ -0,0 +1,81 @@
+package action
+
+import (
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+const encounterLen = 5
+
+// Encounter represents a monster encounter in an MSQ block.  MonsterIdx
+// indexes both the block's monster data and its monster names.
+type Encounter struct {
+	Fixed      bool // B0b7 (always MaxCount monsters)
+	MonsterIdx int  // B0b0,6
+	MinCount   int  // B1
+	MaxCount   int  // B2
+	ToClass    int  // B3
+	ToSelector int  // B4
+
+	Raw []byte // Set if the element could not be decoded.
+}
+
+// DecodeEncounter decodes an encounter from a sequence of bytes.  It returns
+// the decoded encounter and its length (in bytes).
+func DecodeEncounter(data []byte) (*Encounter, int, error) {
+	if len(data) < encounterLen {
+		return nil, 0, wlerr.Errorf(
+			"failed to decode encounter: data length too short: "+
+				"have=%d want>=%d", len(data), encounterLen)
+	}
+
+	return &Encounter{
+		Fixed:      data[0]&0x80 != 0,
+		MonsterIdx: int(data[0] & 0x7f),
+		MinCount:   int(data[1]),
+		MaxCount:   int(data[2]),
+		ToClass:    int(data[3]),
+		ToSelector: int(data[4]),
+	}, encounterLen, nil
+}
+
+// DecodeEncounterTable decodes a set of encounters from a table of byte
+// buffers.  Elements that cannot be decoded losslessly are retained raw.
+func DecodeEncounterTable(table gen.Table) []*Encounter {
+	var es []*Encounter
+
+	for _, elem := range table.Elems {
+		if len(elem) == 0 {
+			es = append(es, nil)
+			continue
+		}
+
+		e, n, err := DecodeEncounter(elem)
+		if needsRaw(elem, n, err, func() []byte { return EncodeEncounter(*e) }) {
+			e = &Encounter{Raw: elem}
+		}
+		es = append(es, e)
+	}
+
+	return es
+}
+
+// EncodeEncounter encodes an encounter to a byte sequence.
+func EncodeEncounter(e Encounter) []byte {
+	if e.Raw != nil {
+		return e.Raw
+	}
+
+	b0 := byte(e.MonsterIdx)
+	if e.Fixed {
+		b0 |= 0x80
+	}
+
+	return []byte{
+		b0,
+		byte(e.MinCount),
+		byte(e.MaxCount),
+		byte(e.ToClass),
+		byte(e.ToSelector),
+	}
+}
@@ -0,0 +1,60 @@
+package action
+
+import (
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// Impassable represents an impassable tile action in an MSQ block.  The party
+// cannot enter the tile; a message is printed instead.
+type Impassable struct {
+	StringID int // B0
+
+	Raw []byte // Set if the element could not be decoded.
+}
+
+// DecodeImpassable decodes an impassable action from a sequence of bytes.  It
+// returns the decoded action and its length (in bytes).
+func DecodeImpassable(data []byte) (*Impassable, int, error) {
+	if len(data) < 1 {
+		return nil, 0, wlerr.Errorf(
+			"failed to decode impassable action: data length too short: "+
+				"have=%d want>=1", len(data))
+	}
+
+	return &Impassable{
+		StringID: int(data[0]),
+	}, 1, nil
+}
+
+// DecodeImpassableTable decodes a set of impassable actions from a table of
+// byte buffers.  Elements that cannot be decoded losslessly are retained raw.
+func DecodeImpassableTable(table gen.Table) []*Impassable {
+	var is []*Impassable
+
+	for _, elem := range table.Elems {
+		if len(elem) == 0 {
+			is = append(is, nil)
+			continue
+		}
+
+		i, n, err := DecodeImpassable(elem)
+		if needsRaw(elem, n, err,
+			func() []byte { return EncodeImpassable(*i) }) {
+
+			i = &Impassable{Raw: elem}
+		}
+		is = append(is, i)
+	}
+
+	return is
+}
+
+// EncodeImpassable encodes an impassable action to a byte sequence.
+func EncodeImpassable(i Impassable) []byte {
+	if i.Raw != nil {
+		return i.Raw
+	}
+
+	return []byte{byte(i.StringID)}
+}
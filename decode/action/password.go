@@ -0,0 +1,89 @@
+package action
+
+import (
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+const passwordMinLen = 6
+
+// Password represents a password prompt action in an MSQ block.  The party
+// is asked for a word; the tile's action changes according to the answer.
+type Password struct {
+	PromptStringID int    // B0
+	PassClass      int    // B1
+	PassSelector   int    // B2
+	FailClass      int    // B3
+	FailSelector   int    // B4
+	Word           string // B5... (null-terminated)
+
+	Raw []byte // Set if the element could not be decoded.
+}
+
+// DecodePassword decodes a password action from a sequence of bytes.  It
+// returns the decoded action and its length (in bytes).
+func DecodePassword(data []byte) (*Password, int, error) {
+	wrapErr := wlerr.MakeWrapper("failed to decode password action")
+
+	if len(data) < passwordMinLen {
+		return nil, 0, wrapErr(nil,
+			"data length too short: have=%d want>=%d",
+			len(data), passwordMinLen)
+	}
+
+	p := &Password{
+		PromptStringID: int(data[0]),
+		PassClass:      int(data[1]),
+		PassSelector:   int(data[2]),
+		FailClass:      int(data[3]),
+		FailSelector:   int(data[4]),
+	}
+
+	word, err := gen.ReadString(data[5:])
+	if err != nil {
+		return nil, 0, wrapErr(err, "failed to read password")
+	}
+	p.Word = word
+
+	return p, 5 + len(word) + 1, nil
+}
+
+// DecodePasswordTable decodes a set of password actions from a table of byte
+// buffers.  Elements that cannot be decoded losslessly are retained raw.
+func DecodePasswordTable(table gen.Table) []*Password {
+	var ps []*Password
+
+	for _, elem := range table.Elems {
+		if len(elem) == 0 {
+			ps = append(ps, nil)
+			continue
+		}
+
+		p, n, err := DecodePassword(elem)
+		if needsRaw(elem, n, err, func() []byte { return EncodePassword(*p) }) {
+			p = &Password{Raw: elem}
+		}
+		ps = append(ps, p)
+	}
+
+	return ps
+}
+
+// EncodePassword encodes a password action to a byte sequence.
+func EncodePassword(p Password) []byte {
+	if p.Raw != nil {
+		return p.Raw
+	}
+
+	b := []byte{
+		byte(p.PromptStringID),
+		byte(p.PassClass),
+		byte(p.PassSelector),
+		byte(p.FailClass),
+		byte(p.FailSelector),
+	}
+	b = append(b, []byte(p.Word)...)
+	b = append(b, 0)
+
+	return b
+}
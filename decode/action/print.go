@@ -0,0 +1,91 @@
+package action
+
+import (
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// Print represents a printed message action in an MSQ block.  It displays a
+// sequence of strings and then changes the tile's action.
+type Print struct {
+	ToClass    int   // B0
+	ToSelector int   // B1
+	StringIDs  []int // B2... (terminated by 0xff)
+
+	Raw []byte // Set if the element could not be decoded.
+}
+
+// DecodePrint decodes a print action from a sequence of bytes.  It returns
+// the decoded action and its length (in bytes).
+func DecodePrint(data []byte) (*Print, int, error) {
+	wrapErr := wlerr.MakeWrapper("failed to decode print action")
+
+	if len(data) < 3 {
+		return nil, 0, wrapErr(nil,
+			"data length too short: have=%d want>=3", len(data))
+	}
+
+	p := &Print{}
+	off := 0
+
+	p.ToClass = int(data[off])
+	off++
+
+	p.ToSelector = int(data[off])
+	off++
+
+	for {
+		if off >= len(data) {
+			return nil, 0, wrapErr(nil, "string list missing terminator byte")
+		}
+
+		b := data[off]
+		off++
+
+		if b == listTerminator {
+			break
+		}
+		p.StringIDs = append(p.StringIDs, int(b))
+	}
+
+	return p, off, nil
+}
+
+// DecodePrintTable decodes a set of print actions from a table of byte
+// buffers.  Elements that cannot be decoded losslessly are retained raw.
+func DecodePrintTable(table gen.Table) []*Print {
+	var ps []*Print
+
+	for _, elem := range table.Elems {
+		if len(elem) == 0 {
+			ps = append(ps, nil)
+			continue
+		}
+
+		p, n, err := DecodePrint(elem)
+		if needsRaw(elem, n, err, func() []byte { return EncodePrint(*p) }) {
+			p = &Print{Raw: elem}
+		}
+		ps = append(ps, p)
+	}
+
+	return ps
+}
+
+// EncodePrint encodes a print action to a byte sequence.
+func EncodePrint(p Print) []byte {
+	if p.Raw != nil {
+		return p.Raw
+	}
+
+	var b []byte
+
+	b = append(b, byte(p.ToClass))
+	b = append(b, byte(p.ToSelector))
+	for _, id := range p.StringIDs {
+		b = append(b, byte(id))
+	}
+	b = append(b, listTerminator)
+
+	return b
+}
@@ -0,0 +1,137 @@
+package action
+
+import (
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+const (
+	shopItemLen        = 4
+	shopItemTerminator = 0xff
+)
+
+// ShopItem represents a single item for sale in a shop.
+type ShopItem struct {
+	ID    int // B0 (see defs.ItemNames)
+	Stock int // B1
+	Price int // B2,3
+}
+
+// Shop represents a shop's inventory in an MSQ block.
+// XXX: The layout (4-byte items followed by 0xff) is provisional.
+type Shop struct {
+	Items []ShopItem
+
+	Raw []byte // Set if the element could not be decoded.
+}
+
+// Name retrieves the name of a shop item.
+func (si *ShopItem) Name() string {
+	return defs.ItemString(si.ID)
+}
+
+// DecodeShopItem decodes a single shop item from a sequence of bytes.
+func DecodeShopItem(data []byte) (*ShopItem, error) {
+	if len(data) < shopItemLen {
+		return nil, wlerr.Errorf(
+			"data length too short: have=%d want>=%d: data=%+v",
+			len(data), shopItemLen, data)
+	}
+
+	if data[0] == shopItemTerminator {
+		return nil, wlerr.Errorf(
+			"shop item has invalid value: have=%d want!=%d",
+			data[0], shopItemTerminator)
+	}
+
+	price, err := gen.ReadUint16(data[2:4])
+	if err != nil {
+		return nil, wlerr.Wrapf(err, "failed to read shop item price")
+	}
+
+	return &ShopItem{
+		ID:    int(data[0]),
+		Stock: int(data[1]),
+		Price: price,
+	}, nil
+}
+
+// DecodeShop decodes a shop inventory from a sequence of bytes.  It returns
+// the decoded shop and its length (in bytes).
+func DecodeShop(data []byte) (*Shop, int, error) {
+	wrapErr := wlerr.MakeWrapper("failed to decode shop")
+
+	shop := &Shop{}
+	off := 0
+
+	for {
+		if off >= len(data) {
+			return nil, 0, wrapErr(nil, "shop inventory missing terminator byte")
+		}
+
+		if data[off] == shopItemTerminator {
+			off++
+			break
+		}
+
+		item, err := DecodeShopItem(data[off:])
+		if err != nil {
+			return nil, 0, wrapErr(err, "")
+		}
+		off += shopItemLen
+
+		shop.Items = append(shop.Items, *item)
+	}
+
+	return shop, off, nil
+}
+
+// DecodeShopTable decodes a set of shops from a table of byte buffers.
+// Elements that cannot be decoded losslessly are retained raw.
+func DecodeShopTable(table gen.Table) []*Shop {
+	var shops []*Shop
+
+	for _, elem := range table.Elems {
+		if len(elem) == 0 {
+			shops = append(shops, nil)
+			continue
+		}
+
+		shop, n, err := DecodeShop(elem)
+		if needsRaw(elem, n, err, func() []byte { return EncodeShop(*shop) }) {
+			shop = &Shop{Raw: elem}
+		}
+		shops = append(shops, shop)
+	}
+
+	return shops
+}
+
+// EncodeShopItem encodes a single shop item to a byte sequence.
+func EncodeShopItem(item ShopItem) []byte {
+	b := make([]byte, shopItemLen)
+
+	b[0] = byte(item.ID)
+	b[1] = byte(item.Stock)
+	copy(b[2:4], gen.WriteUint16(uint16(item.Price)))
+
+	return b
+}
+
+// EncodeShop encodes a shop inventory to a byte sequence.
+func EncodeShop(shop Shop) []byte {
+	if shop.Raw != nil {
+		return shop.Raw
+	}
+
+	var b []byte
+
+	for _, item := range shop.Items {
+		b = append(b, EncodeShopItem(item)...)
+	}
+
+	b = append(b, shopItemTerminator)
+
+	return b
+}
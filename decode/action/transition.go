@@ -16,6 +16,11 @@ const (
 )
 
 // Transition represents a transition (teleport action) in an MSQ block.
+//
+// XXX: StringPtr presumably selects a message for the transition to print,
+// but how it maps to the block's strings is unknown.  It is only 6 bits wide,
+// so it is not a plain string ID (see decode.Strings.Get); until its meaning
+// is established, the message can't be looked up.
 type Transition struct {
 	Relative   bool // B0b7
 	Prompt     bool // B0b6
@@ -100,6 +105,12 @@ func (t *Transition) MakeAbsolute(absCoords gen.Point) {
 	t.LocY = absCoords.Y
 }
 
+// ChangesTile indicates whether the transition changes the action of its tile
+// (i.e., whether ToClass and ToSelector refer to another action).
+func (t *Transition) ChangesTile() bool {
+	return t.ToClass < transitionToClassNoneMin
+}
+
 // IsDerelict indicates whether a transition leads to a derelict building.
 func (t *Transition) IsDerelict() bool {
 	return t.Location != defs.LocationPrevious && t.Location >= 128
@@ -21,6 +21,7 @@ type CarvedBlock struct {
 	MonsterNames   []byte
 	MonsterData    []byte
 	StringsArea    []byte
+	Trailer        []byte // Plain section data following the strings area.
 }
 
 func NewCarvedBlock() *CarvedBlock {
@@ -164,16 +165,26 @@ func CarveBlock(b msq.Body, dim gen.Point) (*CarvedBlock, error) {
 	}
 	cb.Offsets.MonsterData = cd.MonsterData
 
-	_, sasize, err := DecodeStringsArea(b.PlainSection)
+	cb.StringsArea, cb.Trailer, err = SplitPlainSection(b.PlainSection)
 	if err != nil {
-		return nil, wlerr.Wrapf(err, "failed to carve strings area")
+		return nil, wlerr.Wrapf(err, "failed to carve plain section")
 	}
-	cb.StringsArea = b.PlainSection[:sasize]
 	cb.Offsets.StringsArea = cd.Strings
 
 	return cb, nil
 }
 
+// SplitPlainSection partitions a block's plain section into its strings area
+// and the opaque trailer that follows it (see Block.Trailer).
+func SplitPlainSection(plain []byte) ([]byte, []byte, error) {
+	_, sasize, err := DecodeStringsArea(plain)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return plain[:sasize], plain[sasize:], nil
+}
+
 // Sizes indicates the size of each area in a carved block.
 func (cb *CarvedBlock) Sizes() *Meta {
 	m := &Meta{
@@ -94,6 +94,19 @@ func ActionTablePtrPrio(idx int) int {
 	}
 }
 
+// ActionTableIdx is the inverse of ActionTablePtrPrio.  It converts a central
+// directory pointer index to the index of the action table it references.  It
+// returns -1 if the pointer does not reference an action table.
+func ActionTableIdx(cdPtrIdx int) int {
+	for i := 0; i < 16; i++ {
+		if ActionTablePtrPrio(i) == cdPtrIdx {
+			return i
+		}
+	}
+
+	return -1
+}
+
 // DecodeCentralDir parses a central directory from a sequence of bytes.
 func DecodeCentralDir(data []byte) (*CentralDir, error) {
 	if len(data) < CentralDirLen {
@@ -175,6 +188,38 @@ func (cd *CentralDir) Pointers() []int {
 	return ps
 }
 
+// SetPointer assigns a value to a single central directory pointer.
+// cdPtrIdx is the index of the pointer to set (see CDPtrIdx*).
+func (cd *CentralDir) SetPointer(cdPtrIdx int, p int) {
+	switch cdPtrIdx {
+	case CDPtrIdxStrings:
+		cd.Strings = p
+	case CDPtrIdxMonsterNames:
+		cd.MonsterNames = p
+	case CDPtrIdxMonsterData:
+		cd.MonsterData = p
+	case CDPtrIdxSpecialActions:
+		cd.SpecialActions = p
+	case CDPtrIdxNPCTable:
+		cd.NPCTable = p
+	default:
+		idx := ActionTableIdx(cdPtrIdx)
+		if idx < 0 {
+			panic("invalid central directory pointer index: " +
+				strconv.Itoa(cdPtrIdx))
+		}
+		cd.ActionTables[idx] = p
+	}
+}
+
+// AreaIsEmpty indicates whether the area referenced by the specified pointer
+// has a length of 0, either because the pointer is null or because it is
+// aliased by a higher priority pointer.  cdPtrIdx is the index of the pointer
+// to check (see CDPtrIdx*).
+func (cd *CentralDir) AreaIsEmpty(cdPtrIdx int) bool {
+	return cdEntryIs0Len(cdPtrIdx, cd.Pointers())
+}
+
 // EncodeCentralDir encodes a central directory to a byte sequence.
 func EncodeCentralDir(cd CentralDir) []byte {
 	b := make([]byte, CentralDirLen)
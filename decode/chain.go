@@ -0,0 +1,108 @@
+package decode
+
+import "github.com/badvassal/wllib/gen"
+
+// maxActionClass is the greatest valid action class.
+const maxActionClass = 15
+
+// Link is a reference from one action to another.  It indicates the action a
+// tile takes on after an action fires.
+type Link struct {
+	Class    int
+	Selector int
+}
+
+// Links lists the actions that this action can change its tile to.  The
+// primary link (the one taken when the action succeeds or when there is only
+// one outcome) comes first.  Links to invalid classes are omitted.
+func (a *Action) Links() []Link {
+	var ls []Link
+	add := func(class int, selector int) {
+		if class >= 0 && class <= maxActionClass {
+			ls = append(ls, Link{Class: class, Selector: selector})
+		}
+	}
+
+	switch {
+	case a.Print != nil && a.Print.Raw == nil:
+		add(a.Print.ToClass, a.Print.ToSelector)
+
+	case a.Check != nil && a.Check.Raw == nil:
+		add(a.Check.PassClass, a.Check.PassSelector)
+		add(a.Check.FailClass, a.Check.FailSelector)
+
+	case a.Password != nil && a.Password.Raw == nil:
+		add(a.Password.PassClass, a.Password.PassSelector)
+		add(a.Password.FailClass, a.Password.FailSelector)
+
+	case a.Alteration != nil && a.Alteration.Raw == nil:
+		add(a.Alteration.ToClass, a.Alteration.ToSelector)
+
+	case a.Loot != nil:
+		add(a.Loot.ToClass, a.Loot.ToSelector)
+
+	case a.Transition != nil:
+		if a.Transition.ChangesTile() {
+			add(a.Transition.ToClass, a.Transition.ToSelector)
+		}
+
+	case a.Dialogue != nil && a.Dialogue.Raw == nil:
+		for _, o := range a.Dialogue.Options {
+			add(o.ToClass, o.ToSelector)
+		}
+
+	case a.Encounter != nil && a.Encounter.Raw == nil:
+		add(a.Encounter.ToClass, a.Encounter.ToSelector)
+	}
+
+	return ls
+}
+
+// Chain is a sequence of actions connected by their primary links.
+type Chain struct {
+	Actions []*Action
+
+	// CycleIdx is the index of the action that the final action links back
+	// to, or -1 if the chain doesn't contain a cycle.
+	CycleIdx int
+}
+
+// ChainFrom follows the primary links starting at the specified action.  The
+// chain ends at an action with no links (e.g., an empty tile) or when an
+// action repeats.
+func (b *Block) ChainFrom(class int, selector int) Chain {
+	c := Chain{
+		CycleIdx: -1,
+	}
+
+	seen := map[Link]int{}
+
+	cur := Link{Class: class, Selector: selector}
+	for {
+		if idx, ok := seen[cur]; ok {
+			c.CycleIdx = idx
+			return c
+		}
+		seen[cur] = len(c.Actions)
+
+		a := b.ActionFor(cur.Class, cur.Selector)
+		c.Actions = append(c.Actions, a)
+
+		links := a.Links()
+		if len(links) == 0 {
+			return c
+		}
+		cur = links[0]
+	}
+}
+
+// ChainAt follows the chain of actions starting at the specified tile.
+func (b *Block) ChainAt(p gen.Point) (*Chain, error) {
+	a, err := b.ActionAt(p)
+	if err != nil {
+		return nil, err
+	}
+
+	c := b.ChainFrom(a.Class, a.Selector)
+	return &c, nil
+}
@@ -75,6 +75,12 @@ type Character struct {
 	RadioAfterWon   bool        // 4c
 	Skills          []CharSkill // 80-bb
 	Items           []CharItem  // bd-f8
+
+	// Raw is the 256-byte image the character was decoded from.  It retains
+	// the bytes that don't correspond to any of the above fields.  When a
+	// character is encoded, its fields are written on top of this image.
+	// It is nil for a character built from scratch.
+	Raw []byte
 }
 
 // DecodeCharSkill decodes a skill from a sequence of bytes.
@@ -115,7 +121,9 @@ func DecodeCharacter(b []byte) (*Character, error) {
 			"data too short: have=%d want>=%d", len(b), CharacterSize)
 	}
 
-	ch := &Character{}
+	ch := &Character{
+		Raw: append([]byte(nil), b[:CharacterSize]...),
+	}
 
 	var err error
 
@@ -232,17 +240,50 @@ func EncodeCharItem(item CharItem) []byte {
 	}
 }
 
-// EncodeCharacter encodes a PC or an NPC to a byte sequence.
+// encodeCharString writes a null-terminated string to the region b[start:end].
+// If the region already contains the string, it is left untouched so that any
+// data following the terminator is preserved.
+func encodeCharString(b []byte, start int, end int, s string) {
+	cur, err := gen.ReadString(b[start:end])
+	if err == nil && cur == s {
+		return
+	}
+
+	for i := start; i < end; i++ {
+		b[i] = 0
+	}
+	copy(b[start:start+len(s)], []byte(s))
+}
+
+// encodeCharBool writes a boolean to b[off].  If the byte already has the
+// correct truth value, its original value is retained.
+func encodeCharBool(b []byte, off int, v bool) {
+	if (b[off] != 0) != v {
+		b[off] = gen.BoolToByte(v)
+	}
+}
+
+// EncodeCharacter encodes a PC or an NPC to a byte sequence.  If ch.Raw
+// contains the character's original image, the encoded character retains all
+// the bytes that ch's fields don't cover.
 func EncodeCharacter(ch Character) ([]byte, error) {
 	onErr := wlerr.MakeWrapper("failed to encode character")
 
 	b := make([]byte, CharacterSize)
+	if ch.Raw != nil {
+		if len(ch.Raw) != CharacterSize {
+			return nil, onErr(nil,
+				"raw image has wrong size: have=%d want=%d",
+				len(ch.Raw), CharacterSize)
+		}
+		copy(b, ch.Raw)
+	}
 
 	if len(ch.Name) > MaxCharNameLen {
 		return nil, onErr(nil,
 			"name too long: have=%d want<=%d", len(ch.Name), MaxCharNameLen)
 	}
-	copy(b[:len(ch.Name)], []byte(ch.Name))
+	encodeCharString(b, 0x00, 0x0e, ch.Name)
 
 	b[0x0e] = byte(ch.Strength)
 	b[0x0f] = byte(ch.IQ)
@@ -254,7 +295,9 @@ func EncodeCharacter(ch Character) ([]byte, error) {
 
 	copy(b[0x15:0x18], gen.WriteUint24(ch.Money))
 
-	b[0x18] = gen.BoolToByte(ch.IsFemale)
+	if (b[0x18] == SexFemale) != ch.IsFemale {
+		b[0x18] = gen.BoolToByte(ch.IsFemale)
+	}
 	b[0x19] = byte(ch.Nationality)
 	b[0x1a] = byte(ch.AC)
 
@@ -272,7 +315,7 @@ func EncodeCharacter(ch Character) ([]byte, error) {
 	copy(b[0x26:0x28], gen.WriteUint16(uint16(ch.PrevCon)))
 
 	b[0x28] = byte(ch.Afflictions)
-	b[0x29] = byte(gen.BoolToByte(ch.IsNPC))
+	encodeCharBool(b, 0x29, ch.IsNPC)
 	b[0x2b] = byte(ch.RefuseItem)
 	b[0x2c] = byte(ch.RefuseSkill)
 	b[0x2d] = byte(ch.RefuseAttribute)
@@ -284,23 +327,41 @@ func EncodeCharacter(ch Character) ([]byte, error) {
 		return nil, onErr(nil,
 			"rank too long: have=%d want<=%d", len(ch.Rank), MaxCharRankLen)
 	}
-	copy(b[0x32:0x32+len(ch.Rank)], []byte(ch.Rank))
+	encodeCharString(b, 0x32, 0x4b, ch.Rank)
 
-	b[0x4b] = gen.BoolToByte(ch.GameIsWon)
-	b[0x4c] = gen.BoolToByte(ch.RadioAfterWon)
+	encodeCharBool(b, 0x4b, ch.GameIsWon)
+	encodeCharBool(b, 0x4c, ch.RadioAfterWon)
 
+	if len(ch.Skills) > CharNumSkills {
+		return nil, onErr(nil,
+			"too many skills: have=%d want<=%d", len(ch.Skills), CharNumSkills)
+	}
+	if len(ch.Items) > CharNumItems {
+		return nil, onErr(nil,
+			"too many items: have=%d want<=%d", len(ch.Items), CharNumItems)
+	}
+
+	// Unused skill and item slots are cleared.
 	off := 0x80
-	for _, s := range ch.Skills {
+	for i := 0; i < CharNumSkills; i++ {
 		end := off + CharSkillSize
-		copy(b[off:end], EncodeCharSkill(s))
+		if i < len(ch.Skills) {
+			copy(b[off:end], EncodeCharSkill(ch.Skills[i]))
+		} else {
+			copy(b[off:end], make([]byte, CharSkillSize))
+		}
 
 		off = end
 	}
 
 	off = 0xbd
-	for _, i := range ch.Items {
+	for i := 0; i < CharNumItems; i++ {
 		end := off + CharItemSize
-		copy(b[off:end], EncodeCharItem(i))
+		if i < len(ch.Items) {
+			copy(b[off:end], EncodeCharItem(ch.Items[i]))
+		} else {
+			copy(b[off:end], make([]byte, CharItemSize))
+		}
 
 		off = end
 	}
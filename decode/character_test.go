@@ -0,0 +1,75 @@
+package decode
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testCharImage() []byte {
+	b := make([]byte, CharacterSize)
+	for i := range b {
+		b[i] = byte(i*7 + 3)
+	}
+
+	// Name and rank, each followed by leftover garbage.
+	copy(b[0x00:0x0e], []byte("Hell\x00garbage!!"))
+	copy(b[0x32:0x4b], []byte("Ranger\x00more garbage here"))
+
+	// Boolean fields with nonstandard truth values.
+	b[0x29] = 0xff
+	b[0x4b] = 0x02
+	b[0x4c] = 0x00
+
+	return b
+}
+
+func TestCharacterRoundTrip(t *testing.T) {
+	img := testCharImage()
+
+	ch, err := DecodeCharacter(img)
+	if err != nil {
+		t.Fatalf("failed to decode character: %v", err)
+	}
+
+	b, err := EncodeCharacter(*ch)
+	if err != nil {
+		t.Fatalf("failed to encode character: %v", err)
+	}
+
+	if !bytes.Equal(b, img) {
+		t.Fatalf("round trip not byte-identical:\nhave=%x\nwant=%x", b, img)
+	}
+}
+
+func TestCharacterEditPreservesUnknown(t *testing.T) {
+	img := testCharImage()
+
+	ch, err := DecodeCharacter(img)
+	if err != nil {
+		t.Fatalf("failed to decode character: %v", err)
+	}
+
+	ch.Name = "Angela"
+	ch.Strength = 20
+	ch.IsNPC = false
+
+	b, err := EncodeCharacter(*ch)
+	if err != nil {
+		t.Fatalf("failed to encode character: %v", err)
+	}
+
+	for _, off := range []int{0x2a, 0x2f, 0x4d, 0x7f, 0xbb, 0xbc, 0xf9, 0xff} {
+		if b[off] != img[off] {
+			t.Errorf("unknown byte 0x%02x changed: have=0x%02x want=0x%02x",
+				off, b[off], img[off])
+		}
+	}
+
+	ch2, err := DecodeCharacter(b)
+	if err != nil {
+		t.Fatalf("failed to decode edited character: %v", err)
+	}
+	if ch2.Name != "Angela" || ch2.Strength != 20 || ch2.IsNPC {
+		t.Fatalf("edits not applied: %+v", ch2)
+	}
+}
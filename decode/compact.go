@@ -0,0 +1,354 @@
+package decode
+
+import (
+	"github.com/badvassal/wllib/decode/action"
+)
+
+// actionTableLen returns the number of elements in the action table with the
+// specified index.
+func actionTableLen(t *action.Tables, idx int) int {
+	switch idx {
+	case action.IDPrint:
+		return len(t.Prints)
+	case action.IDCheck:
+		return len(t.Checks)
+	case action.IDPassword:
+		return len(t.Passwords)
+	case action.IDAlteration:
+		return len(t.Alterations)
+	case action.IDLoot:
+		return len(t.Loots)
+	case action.IDShop:
+		return len(t.Shops)
+	case action.IDImpassable:
+		return len(t.Impassables)
+	case action.IDTransition:
+		return len(t.Transitions)
+	case action.IDDialogue:
+		return len(t.Dialogues)
+	case action.IDEncounter:
+		return len(t.Encounters)
+	default:
+		if rt := rawTable(*t, idx); rt != nil {
+			return len(rt.Elems)
+		}
+		return 0
+	}
+}
+
+// keepActionTableElems reduces an action table to the elements at the given
+// indices, in the given order.
+func keepActionTableElems(t *action.Tables, idx int, keep []int) {
+	switch idx {
+	case action.IDPrint:
+		old := t.Prints
+		t.Prints = nil
+		for _, i := range keep {
+			t.Prints = append(t.Prints, old[i])
+		}
+	case action.IDCheck:
+		old := t.Checks
+		t.Checks = nil
+		for _, i := range keep {
+			t.Checks = append(t.Checks, old[i])
+		}
+	case action.IDPassword:
+		old := t.Passwords
+		t.Passwords = nil
+		for _, i := range keep {
+			t.Passwords = append(t.Passwords, old[i])
+		}
+	case action.IDAlteration:
+		old := t.Alterations
+		t.Alterations = nil
+		for _, i := range keep {
+			t.Alterations = append(t.Alterations, old[i])
+		}
+	case action.IDLoot:
+		old := t.Loots
+		t.Loots = nil
+		for _, i := range keep {
+			t.Loots = append(t.Loots, old[i])
+		}
+	case action.IDShop:
+		old := t.Shops
+		t.Shops = nil
+		for _, i := range keep {
+			t.Shops = append(t.Shops, old[i])
+		}
+	case action.IDImpassable:
+		old := t.Impassables
+		t.Impassables = nil
+		for _, i := range keep {
+			t.Impassables = append(t.Impassables, old[i])
+		}
+	case action.IDTransition:
+		old := t.Transitions
+		t.Transitions = nil
+		for _, i := range keep {
+			t.Transitions = append(t.Transitions, old[i])
+		}
+	case action.IDDialogue:
+		old := t.Dialogues
+		t.Dialogues = nil
+		for _, i := range keep {
+			t.Dialogues = append(t.Dialogues, old[i])
+		}
+	case action.IDEncounter:
+		old := t.Encounters
+		t.Encounters = nil
+		for _, i := range keep {
+			t.Encounters = append(t.Encounters, old[i])
+		}
+	default:
+		rt := rawTable(*t, idx)
+		if rt == nil {
+			return
+		}
+
+		old := rt.Elems
+		var elems [][]byte
+		for _, i := range keep {
+			elems = append(elems, old[i])
+		}
+
+		switch idx {
+		case 0:
+			t.T0.Elems = elems
+		case 8:
+			t.T8.Elems = elems
+		case 9:
+			t.T9.Elems = elems
+		case 12:
+			t.T12.Elems = elems
+		case 14:
+			t.T14.Elems = elems
+		case 15:
+			t.T15.Elems = elems
+		}
+	}
+}
+
+// ReferencedActions determines which actions can be reached from the map.  An
+// action is referenced if a tile uses it, if an alteration assigns it to a
+// tile, or if a referenced action links to it.
+// XXX: References held in raw (undecoded) elements and in special actions are
+// not detected.
+func (b *Block) ReferencedActions() map[Link]bool {
+	refs := map[Link]bool{}
+
+	var queue []Link
+	visit := func(l Link) {
+		if !refs[l] {
+			refs[l] = true
+			queue = append(queue, l)
+		}
+	}
+
+	md := &b.MapData
+	for y, row := range md.ActionClasses {
+		for x, c := range row {
+			visit(Link{Class: c, Selector: md.ActionSelectors[y][x]})
+		}
+	}
+
+	for len(queue) > 0 {
+		l := queue[0]
+		queue = queue[1:]
+
+		a := b.ActionFor(l.Class, l.Selector)
+		for _, next := range a.Links() {
+			visit(next)
+		}
+
+		if a.Alteration != nil && a.Alteration.Raw == nil {
+			for _, e := range a.Alteration.Elems {
+				if e.Class >= 0 && e.Class <= maxActionClass {
+					visit(Link{Class: e.Class, Selector: e.Selector})
+				}
+			}
+		}
+	}
+
+	return refs
+}
+
+// UnreferencedActions lists the action table elements that nothing refers to
+// (see ReferencedActions).  Null elements are not listed.  The result is
+// sorted by class, then selector.
+func (b *Block) UnreferencedActions() []Link {
+	refs := b.ReferencedActions()
+
+	var dead []Link
+	for class := 0; class <= maxActionClass; class++ {
+		n := actionTableLen(&b.ActionTables, class)
+		for sel := 0; sel < n; sel++ {
+			l := Link{Class: class, Selector: sel}
+			if !refs[l] && !b.ActionFor(class, sel).None() {
+				dead = append(dead, l)
+			}
+		}
+	}
+
+	return dead
+}
+
+// isRaw indicates whether an action is an element that wasn't decoded, either
+// because it belongs to an untyped table or because it didn't fit its type's
+// layout.
+func (a *Action) isRaw() bool {
+	switch {
+	case a.Raw != nil:
+		return true
+	case a.Print != nil:
+		return a.Print.Raw != nil
+	case a.Check != nil:
+		return a.Check.Raw != nil
+	case a.Password != nil:
+		return a.Password.Raw != nil
+	case a.Alteration != nil:
+		return a.Alteration.Raw != nil
+	case a.Shop != nil:
+		return a.Shop.Raw != nil
+	case a.Impassable != nil:
+		return a.Impassable.Raw != nil
+	case a.Dialogue != nil:
+		return a.Dialogue.Raw != nil
+	case a.Encounter != nil:
+		return a.Encounter.Raw != nil
+	default:
+		return false
+	}
+}
+
+// compactableClass indicates whether the elements of an action table can be
+// removed and renumbered.  A table with a raw element can't: the element's
+// links can't be updated, and it may be the target of links we can't see.
+// The shop table can't either if the block has special actions, because its
+// raw elements are the entry points into the special actions bytecode.
+func (b *Block) compactableClass(class int) bool {
+	if class == action.IDShop && len(b.SpecialActions.Actions) > 0 {
+		return false
+	}
+
+	n := actionTableLen(&b.ActionTables, class)
+	for sel := 0; sel < n; sel++ {
+		if b.ActionFor(class, sel).isRaw() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CompactActions removes every unreferenced action table element and
+// renumbers the remaining elements.  Null elements are kept.  Tile selectors
+// and the links in typed actions are updated to match.  It returns a map from
+// each surviving action's old link to its new one, and the classes that were
+// left as they are.
+//
+// Compaction is done per class.  A class is left as it is if its table
+// contains a raw element (including any element of an untyped table), or if
+// it is the shop class and the block has special actions (see
+// compactableClass).  Other classes are compacted.
+// XXX: Links held in raw elements and in special actions can't be seen, so
+// they are assumed not to refer to the classes that get compacted.
+func (b *Block) CompactActions() (map[Link]Link, []int) {
+	t := &b.ActionTables
+
+	var skipped []int
+	compact := map[int]bool{}
+	for class := 0; class <= maxActionClass; class++ {
+		if b.compactableClass(class) {
+			compact[class] = true
+		} else {
+			skipped = append(skipped, class)
+		}
+	}
+
+	refs := b.ReferencedActions()
+
+	remap := map[Link]Link{}
+	for class := 0; class <= maxActionClass; class++ {
+		if !compact[class] {
+			continue
+		}
+
+		var keep []int
+		n := actionTableLen(t, class)
+		for sel := 0; sel < n; sel++ {
+			old := Link{Class: class, Selector: sel}
+			if refs[old] || b.ActionFor(class, sel).None() {
+				remap[old] = Link{Class: class, Selector: len(keep)}
+				keep = append(keep, sel)
+			}
+		}
+
+		keepActionTableElems(t, class, keep)
+	}
+
+	// Renumber a class/selector pair in place.  Pairs that don't refer to a
+	// table element are left alone.
+	fix := func(class int, sel *int) {
+		if nl, ok := remap[Link{Class: class, Selector: *sel}]; ok {
+			*sel = nl.Selector
+		}
+	}
+
+	md := &b.MapData
+	for y, row := range md.ActionClasses {
+		for x, c := range row {
+			fix(c, &md.ActionSelectors[y][x])
+		}
+	}
+
+	for _, p := range t.Prints {
+		if p != nil && p.Raw == nil {
+			fix(p.ToClass, &p.ToSelector)
+		}
+	}
+	for _, c := range t.Checks {
+		if c != nil && c.Raw == nil {
+			fix(c.PassClass, &c.PassSelector)
+			fix(c.FailClass, &c.FailSelector)
+		}
+	}
+	for _, p := range t.Passwords {
+		if p != nil && p.Raw == nil {
+			fix(p.PassClass, &p.PassSelector)
+			fix(p.FailClass, &p.FailSelector)
+		}
+	}
+	for _, a := range t.Alterations {
+		if a != nil && a.Raw == nil {
+			fix(a.ToClass, &a.ToSelector)
+			for i := range a.Elems {
+				fix(a.Elems[i].Class, &a.Elems[i].Selector)
+			}
+		}
+	}
+	for _, l := range t.Loots {
+		if l != nil {
+			fix(l.ToClass, &l.ToSelector)
+		}
+	}
+	for _, tr := range t.Transitions {
+		if tr != nil && tr.ChangesTile() {
+			fix(tr.ToClass, &tr.ToSelector)
+		}
+	}
+	for _, d := range t.Dialogues {
+		if d != nil && d.Raw == nil {
+			for i := range d.Options {
+				fix(d.Options[i].ToClass, &d.Options[i].ToSelector)
+			}
+		}
+	}
+	for _, e := range t.Encounters {
+		if e != nil && e.Raw == nil {
+			fix(e.ToClass, &e.ToSelector)
+		}
+	}
+
+	return remap, skipped
+}
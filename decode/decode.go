@@ -38,11 +38,18 @@ type Block struct {
 	MonsterNames   MonsterNames
 	MonsterData    MonsterData
 	StringsArea    StringsArea
+
+	// Trailer is the plain section data following the strings area.
+	// XXX: It is believed to contain the map's tile layer, but the tile
+	// layer's format (including any compression or size header) hasn't been
+	// verified against GAME data.  Until it is, the trailer is kept opaque.
+	Trailer []byte
 }
 
 // DecodeState is fully decoded saved game.
 type DecodeState struct {
-	Blocks [][]Block // [game-idx][block-idx]
+	Blocks    [][]Block  // [game-idx][block-idx]
+	SaveState *SaveState // Nil if GAME1 has no save state block.
 }
 
 // ValidateMapDim ensures the provided dimensions are valid for a block map.
@@ -152,19 +159,19 @@ func DecodeBlock(body msq.Body, dim gen.Point) (*Block, error) {
 		MapInfo:    *mi,
 		ActionTables: action.Tables{
 			T0:          tables[0],
-			T1:          tables[1],
-			T2:          tables[2],
-			T3:          tables[3],
-			T4:          tables[4],
+			Prints:      action.DecodePrintTable(tables[1]),
+			Checks:      action.DecodeCheckTable(tables[2]),
+			Passwords:   action.DecodePasswordTable(tables[3]),
+			Alterations: action.DecodeAlterationTable(tables[4]),
 			Loots:       loots,
-			T6:          tables[6],
-			T7:          tables[7],
+			Shops:       action.DecodeShopTable(tables[action.IDShop]),
+			Impassables: action.DecodeImpassableTable(tables[7]),
 			T8:          tables[8],
 			T9:          tables[9],
 			Transitions: ts,
-			T11:         tables[11],
+			Dialogues:   action.DecodeDialogueTable(tables[11]),
 			T12:         tables[12],
-			T13:         tables[13],
+			Encounters:  action.DecodeEncounterTable(tables[13]),
 			T14:         tables[14],
 			T15:         tables[15],
 		},
@@ -173,5 +180,6 @@ func DecodeBlock(body msq.Body, dim gen.Point) (*Block, error) {
 		MonsterNames:   *mn,
 		MonsterData:    *mo,
 		StringsArea:    *sa,
+		Trailer:        cb.Trailer,
 	}, nil
 }
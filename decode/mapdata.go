@@ -77,7 +77,7 @@ func EncodeMapData(md MapData) []byte {
 
 	off := 0
 	for y := 0; y < dim.Y; y++ {
-		for x := 0; x < dim.X; x++ {
+		for x := 0; x < dim.X; x += 2 {
 			ac1 := md.ActionClasses[y][x]
 			ac2 := md.ActionClasses[y][x+1]
 			b[off] = byte(ac2)<<4 | byte(ac1)
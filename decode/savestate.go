@@ -0,0 +1,121 @@
+package decode
+
+import (
+	"github.com/badvassal/wllib/gen/wlerr"
+	"github.com/badvassal/wllib/msq"
+)
+
+const (
+	SaveStateMinLen  = 0x800
+	SaveStateCharOff = 0x100
+	SaveStateNumChar = 7
+)
+
+// SaveState is the party's saved progress.  It lives in the secure section of
+// the first non-map block of GAME1 (block index defs.Block0NumBlocks).  See
+// <https://wasteland.gamepedia.com/Savegame>.
+// XXX: Only the character roster is well understood.  The offsets of the
+// position and clock fields are provisional.  The remaining bytes (including
+// the game's event flags) are retained in Raw and written back unchanged.
+type SaveState struct {
+	PartyOrder []int       // 00-06 (roster slot of each party position)
+	PosX       int         // 08
+	PosY       int         // 09
+	Location   int         // 0a
+	PrevLoc    int         // 0b
+	Minute     int         // 1b
+	Hour       int         // 1c
+	Day        int         // 1d
+	Characters []Character // 100-7ff
+
+	// Raw is the secure section the save state was decoded from.  When the
+	// save state is encoded, its fields are written on top of this image.
+	Raw []byte
+}
+
+// DecodeSaveState decodes the save state from a non-map MSQ block.
+func DecodeSaveState(body msq.Body) (*SaveState, error) {
+	onErr := wlerr.MakeWrapper("failed to decode save state")
+
+	b := body.SecSection
+	if len(b) < SaveStateMinLen {
+		return nil, onErr(nil,
+			"data too short: have=%d want>=%d", len(b), SaveStateMinLen)
+	}
+
+	ss := &SaveState{
+		Raw: append([]byte(nil), b...),
+	}
+
+	for i := 0; i < SaveStateNumChar; i++ {
+		ss.PartyOrder = append(ss.PartyOrder, int(b[i]))
+	}
+
+	ss.PosX = int(b[0x08])
+	ss.PosY = int(b[0x09])
+	ss.Location = int(b[0x0a])
+	ss.PrevLoc = int(b[0x0b])
+	ss.Minute = int(b[0x1b])
+	ss.Hour = int(b[0x1c])
+	ss.Day = int(b[0x1d])
+
+	for i := 0; i < SaveStateNumChar; i++ {
+		off := SaveStateCharOff + i*CharacterSize
+		ch, err := DecodeCharacter(b[off : off+CharacterSize])
+		if err != nil {
+			return nil, onErr(err, "character %d", i)
+		}
+
+		ss.Characters = append(ss.Characters, *ch)
+	}
+
+	return ss, nil
+}
+
+// EncodeSaveState encodes a save state to a byte sequence suitable for use as
+// a block's secure section.  ss.Raw must contain the original image.
+func EncodeSaveState(ss SaveState) ([]byte, error) {
+	onErr := wlerr.MakeWrapper("failed to encode save state")
+
+	if len(ss.Raw) < SaveStateMinLen {
+		return nil, onErr(nil,
+			"raw image too short: have=%d want>=%d",
+			len(ss.Raw), SaveStateMinLen)
+	}
+	if len(ss.PartyOrder) > SaveStateNumChar {
+		return nil, onErr(nil,
+			"party too large: have=%d want<=%d",
+			len(ss.PartyOrder), SaveStateNumChar)
+	}
+	if len(ss.Characters) != SaveStateNumChar {
+		return nil, onErr(nil,
+			"wrong number of characters: have=%d want=%d",
+			len(ss.Characters), SaveStateNumChar)
+	}
+
+	b := append([]byte(nil), ss.Raw...)
+
+	for i, slot := range ss.PartyOrder {
+		b[i] = byte(slot)
+	}
+
+	b[0x08] = byte(ss.PosX)
+	b[0x09] = byte(ss.PosY)
+	b[0x0a] = byte(ss.Location)
+	b[0x0b] = byte(ss.PrevLoc)
+	b[0x1b] = byte(ss.Minute)
+	b[0x1c] = byte(ss.Hour)
+	b[0x1d] = byte(ss.Day)
+
+	for i, ch := range ss.Characters {
+		cb, err := EncodeCharacter(ch)
+		if err != nil {
+			return nil, onErr(err, "character %d", i)
+		}
+
+		off := SaveStateCharOff + i*CharacterSize
+		copy(b[off:off+CharacterSize], cb)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,74 @@
+package decode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/badvassal/wllib/msq"
+)
+
+func testSaveStateImage() []byte {
+	b := make([]byte, SaveStateMinLen+0x10)
+	for i := range b {
+		b[i] = byte(i*13 + 5)
+	}
+
+	for i := 0; i < SaveStateNumChar; i++ {
+		off := SaveStateCharOff + i*CharacterSize
+		copy(b[off:off+CharacterSize], testCharImage())
+	}
+
+	return b
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	img := testSaveStateImage()
+
+	ss, err := DecodeSaveState(msq.Body{SecSection: img})
+	if err != nil {
+		t.Fatalf("failed to decode save state: %v", err)
+	}
+
+	b, err := EncodeSaveState(*ss)
+	if err != nil {
+		t.Fatalf("failed to encode save state: %v", err)
+	}
+
+	if !bytes.Equal(b, img) {
+		t.Fatalf("round trip not byte-identical:\nhave=%x\nwant=%x", b, img)
+	}
+}
+
+func TestSaveStateEditPreservesUnknown(t *testing.T) {
+	img := testSaveStateImage()
+
+	ss, err := DecodeSaveState(msq.Body{SecSection: img})
+	if err != nil {
+		t.Fatalf("failed to decode save state: %v", err)
+	}
+
+	ss.Day = 3
+	ss.Characters[1].Strength = 20
+
+	b, err := EncodeSaveState(*ss)
+	if err != nil {
+		t.Fatalf("failed to encode save state: %v", err)
+	}
+
+	for i := range img {
+		switch i {
+		case 0x1d, SaveStateCharOff + CharacterSize + 0x0e:
+			continue
+		}
+		if b[i] != img[i] {
+			t.Fatalf("byte %#x changed: have=%#x want=%#x", i, b[i], img[i])
+		}
+	}
+	if b[0x1d] != 3 {
+		t.Fatalf("day not written: have=%d want=3", b[0x1d])
+	}
+
+	if _, err := DecodeSaveState(msq.Body{SecSection: img[:0x7ff]}); err == nil {
+		t.Fatalf("truncated save state decoded without error")
+	}
+}
@@ -1,6 +1,9 @@
 package decode
 
 // ShopData represents an MSQ block's set of shops.
+//
+// Deprecated: Shops are decoded into Block.ActionTables.Shops; see
+// action.DecodeShopTable.
 type ShopData struct {
 	Shops []byte
 }
@@ -8,6 +11,8 @@ type ShopData struct {
 // DecodeShopData parses a set of shops from a sequence of bytes.  baseOff is
 // the offset of the start of the shop data relative to the start of the MSQ
 // block's secure section.
+//
+// Deprecated: Use action.DecodeShopTable.
 func DecodeShopData(data []byte, baseOff int) (*ShopData, error) {
 	return &ShopData{
 		Shops: data,
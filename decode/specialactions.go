@@ -1,6 +1,12 @@
 package decode
 
 // SpecialActions represents an MSQ block's set of special actions (action class 6).
+// The actions are stored as raw bytecode.  The bytecode may contain absolute
+// pointers, so the area must stay at the offset it was decoded from.
+//
+// XXX: The bytecode's instruction set is undocumented, so there is no
+// disassembler or assembler for it yet.  Any opcode table written now would
+// be a guess.
 type SpecialActions struct {
 	Actions []byte
 }
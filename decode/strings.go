@@ -0,0 +1,70 @@
+package decode
+
+import (
+	"bytes"
+
+	"github.com/badvassal/wllib/gen/wlerr"
+	"github.com/badvassal/wllib/wlstrings"
+)
+
+// Strings contains the decompressed text of a strings area, split into
+// individual messages.  Each group contains wlstrings.StringsPerGroup strings.
+type Strings struct {
+	Groups [][]string
+}
+
+// DecodeStrings decompresses every group in a strings area and splits the
+// groups into individual strings.
+func DecodeStrings(sa StringsArea) (*Strings, error) {
+	onErr := wlerr.MakeWrapper("failed to decode strings")
+
+	s := &Strings{}
+	for i := range sa.Pointers {
+		cg, err := sa.GroupData(i)
+		if err != nil {
+			return nil, onErr(err, "")
+		}
+
+		dg, err := wlstrings.DecompressStringGroup(sa.CharTable, cg)
+		if err != nil {
+			return nil, onErr(err, "group %d", i)
+		}
+
+		// Each string is null-terminated.  Anything after the final
+		// terminator is padding.
+		parts := bytes.Split(dg, []byte{0})
+		if len(parts) <= wlstrings.StringsPerGroup {
+			return nil, onErr(nil,
+				"group %d truncated: have=%d strings want=%d",
+				i, len(parts)-1, wlstrings.StringsPerGroup)
+		}
+
+		g := make([]string, wlstrings.StringsPerGroup)
+		for j := range g {
+			g[j] = string(parts[j])
+		}
+		s.Groups = append(s.Groups, g)
+	}
+
+	return s, nil
+}
+
+// Len returns the number of strings in the set.
+func (s *Strings) Len() int {
+	return len(s.Groups) * wlstrings.StringsPerGroup
+}
+
+// Get retrieves a single string by ID.  An ID selects a group (id /
+// StringsPerGroup) and a string within the group (id % StringsPerGroup).
+// This is the form used by MapInfo.StringIDs and Character.JoinStringIdx.
+// XXX: Transition.StringPtr is not known to use this form, so the message a
+// transition prints cannot be looked up yet (see action.Transition).
+func (s *Strings) Get(id int) (string, error) {
+	if id < 0 || id >= s.Len() {
+		return "", wlerr.Errorf("invalid string ID: have=%d want>=0&&<%d",
+			id, s.Len())
+	}
+
+	return s.Groups[id/wlstrings.StringsPerGroup][id%wlstrings.StringsPerGroup],
+		nil
+}
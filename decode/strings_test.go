@@ -0,0 +1,78 @@
+package decode
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/wlstrings"
+)
+
+// buildStringsArea compresses groups of null-terminated strings into a
+// strings area.
+func buildStringsArea(t *testing.T, groups [][]byte) StringsArea {
+	table, err := wlstrings.BuildCharTable(groups)
+	if err != nil {
+		t.Fatalf("failed to build character table: %v", err)
+	}
+
+	sa := StringsArea{
+		CharTable: table,
+	}
+
+	ptrAreaSize := (len(groups) + 1) * 2
+	for _, g := range groups {
+		cg, err := wlstrings.CompressStringGroup(table, g)
+		if err != nil {
+			t.Fatalf("failed to compress string group: %v", err)
+		}
+
+		sa.Pointers = append(sa.Pointers, ptrAreaSize+len(sa.StringData))
+		sa.StringData = append(sa.StringData, cg...)
+	}
+	sa.EndPointer = ptrAreaSize + len(sa.StringData)
+
+	return sa
+}
+
+func TestDecodeStrings(t *testing.T) {
+	sa := buildStringsArea(t, [][]byte{
+		[]byte("zero\x00one\x00\x00three\x00"),
+		[]byte("four\x00five\x00six\x00seven\x00"),
+	})
+
+	strs, err := DecodeStrings(sa)
+	if err != nil {
+		t.Fatalf("failed to decode strings: %v", err)
+	}
+
+	if strs.Len() != 8 {
+		t.Fatalf("wrong string count: have=%d want=8", strs.Len())
+	}
+
+	want := []string{"zero", "one", "", "three", "four", "five", "six",
+		"seven"}
+	for id, w := range want {
+		s, err := strs.Get(id)
+		if err != nil {
+			t.Fatalf("failed to get string %d: %v", id, err)
+		}
+		if s != w {
+			t.Fatalf("wrong string %d: have=%q want=%q", id, s, w)
+		}
+	}
+
+	for _, id := range []int{-1, 8} {
+		if _, err := strs.Get(id); err == nil {
+			t.Fatalf("get succeeded with invalid ID %d", id)
+		}
+	}
+}
+
+func TestDecodeStringsTruncated(t *testing.T) {
+	sa := buildStringsArea(t, [][]byte{
+		[]byte("zero\x00one\x00"),
+	})
+
+	if _, err := DecodeStrings(sa); err == nil {
+		t.Fatalf("decode succeeded with truncated group")
+	}
+}
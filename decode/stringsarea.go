@@ -3,11 +3,12 @@ package decode
 import (
 	"github.com/badvassal/wllib/gen"
 	"github.com/badvassal/wllib/gen/wlerr"
+	"github.com/badvassal/wllib/wlstrings"
 	log "github.com/sirupsen/logrus"
 )
 
-// CentralDirLen is the size, in bytes, of the character table at the start of
-// a strings area.
+// StringsCharacterTableLen is the size, in bytes, of the character table at
+// the start of a strings area.
 const StringsCharacterTableLen = 60
 
 // StringsArea contains most of the text for a single MSQ block.  See
@@ -15,15 +16,51 @@ const StringsCharacterTableLen = 60
 // XXX: This structure should contain only decompressed strings.  The character
 // table and pointers should be derived when the area gets re-encoded.  There
 // are currently some issues with string decoding, so for now we store the
-// encoded contents.
+// encoded contents.  Use digest.CompressStringsArea to build a strings area
+// from plaintext.
 type StringsArea struct {
 	CharTable  []byte
-	Pointers   []int
+	Pointers   []int // One per string group.
 	StringData []byte
+
+	// EndPointer is the final entry in the pointer list.  It does not point
+	// to a string group.  It appears to mark the end of the string data, but
+	// in some blocks it points outside the strings area entirely, so it is
+	// only trusted when it agrees with the final group's contents (see
+	// DecodeStringsArea).  It is preserved, unadjusted, so that the area
+	// re-encodes unchanged.
+	EndPointer int
 }
 
-// DecodeCentralDir parses a stringsd area from a sequence of bytes.  It
-// returns the decoded strings area and the size of the area, in bytes.
+// finalGroupLen determines the size, in bytes, of the final string group of
+// a strings area.  The group is followed by unrelated data and nothing marks
+// its end.  If the end pointer (relative to the start of the group) falls
+// right after one of the group's strings, it is taken as the end.  Otherwise,
+// the group is assumed to hold wlstrings.StringsPerGroup strings; if it can't
+// be decoded that far, it is assumed to end after its last decodable string.
+func finalGroupLen(chars []byte, group []byte, endHint int) int {
+	ends, err := wlstrings.StringEnds(chars, group)
+	for _, e := range ends {
+		if e == endHint {
+			return e
+		}
+	}
+
+	if err != nil {
+		log.Debugf("end of final string group is uncertain: %v", err)
+	}
+	if len(ends) == 0 {
+		return 0
+	}
+
+	return ends[len(ends)-1]
+}
+
+// DecodeStringsArea parses a strings area from a sequence of bytes.  It
+// returns the decoded strings area and the size of the area, in bytes.  data
+// may extend beyond the end of the strings area.  The end of the area is
+// derived as described in finalGroupLen; a final group that can't be fully
+// decoded does not cause an error.
 func DecodeStringsArea(data []byte) (*StringsArea, int, error) {
 	onErr := wlerr.MakeWrapper("failed to decode strings area")
 
@@ -42,18 +79,23 @@ func DecodeStringsArea(data []byte) (*StringsArea, int, error) {
 		return p
 	}
 
+	if len(data)-off < 2 {
+		return nil, 0, onErr(nil, "pointer area truncated: len(data)=%d",
+			len(data))
+	}
+
 	// Read the first pointer to determine the offset of the first string.
 	// From this, we can derive the end of the pointer list.
 	p := readPtr()
 	ptrAreaSize := p
-	if ptrAreaSize > len(data)-off {
+	if ptrAreaSize > len(data)-StringsCharacterTableLen {
 		return nil, 0, onErr(nil,
 			"pointer area truncated: p1=%d off=%d len(data)=%d",
 			p, off, len(data))
 	}
-	if ptrAreaSize%2 != 0 {
+	if ptrAreaSize < 2 || ptrAreaSize%2 != 0 {
 		return nil, 0, onErr(nil,
-			"pointer region has invalid length: have=%d want%%2",
+			"pointer region has invalid length: have=%d want%%2&&>=2",
 			ptrAreaSize)
 	}
 
@@ -63,16 +105,32 @@ func DecodeStringsArea(data []byte) (*StringsArea, int, error) {
 		pointers[i] = readPtr()
 	}
 
-	// Sometimes the final pointer is garbage for some reason?
-	// XXX: Just exclude the final string group for now.
+	// The final pointer doesn't start a string group.
+	endPtr := pointers[len(pointers)-1]
+	pointers = pointers[:len(pointers)-1]
+
+	dataStart := StringsCharacterTableLen + ptrAreaSize
+	dataEnd := dataStart
+
+	for i, p := range pointers {
+		if p < ptrAreaSize || (i > 0 && p < pointers[i-1]) {
+			return nil, 0, onErr(nil,
+				"invalid string group pointer: idx=%d p=%d ptrs=%+v",
+				i, p, pointers)
+		}
+		if StringsCharacterTableLen+p > len(data) {
+			return nil, 0, onErr(nil,
+				"string group pointer beyond end of data: idx=%d p=%d "+
+					"len(data)=%d", i, p, len(data))
+		}
+	}
+
 	if len(pointers) > 0 {
-		pointers = pointers[:len(pointers)-1]
+		lastStart := StringsCharacterTableLen + pointers[len(pointers)-1]
+		dataEnd = lastStart + finalGroupLen(chars, data[lastStart:],
+			StringsCharacterTableLen+endPtr-lastStart)
 	}
 
-	dataStart := StringsCharacterTableLen + pointers[0]
-	// XXX: We don't know where the strings area ends.  Just assume the last
-	// string is 10 bytes long for now.
-	dataEnd := StringsCharacterTableLen + pointers[len(pointers)-1] + 10
 	log.Debugf("decoding strings area: start=%d end=%d len(data)=%d",
 		dataStart, dataEnd, len(data))
 	stringData, err := gen.ExtractBlob(data, dataStart, dataEnd)
@@ -84,5 +142,51 @@ func DecodeStringsArea(data []byte) (*StringsArea, int, error) {
 		CharTable:  chars,
 		Pointers:   pointers,
 		StringData: stringData,
+		EndPointer: endPtr,
 	}, dataEnd, nil
 }
+
+// EncodeStringsArea encodes a strings area to a byte sequence.
+func EncodeStringsArea(sa StringsArea) []byte {
+	var b []byte
+
+	b = append(b, sa.CharTable...)
+
+	// Pointers are relative to the start of the pointer list.  Adjust them in
+	// case the number of groups changed.
+	ptrAreaSize := (len(sa.Pointers) + 1) * 2
+	delta := ptrAreaSize
+	if len(sa.Pointers) > 0 {
+		delta -= sa.Pointers[0]
+	}
+
+	for _, p := range sa.Pointers {
+		b = append(b, gen.WriteUint16(uint16(p+delta))...)
+	}
+	// The end pointer's meaning is unknown, so it is written back verbatim.
+	b = append(b, gen.WriteUint16(uint16(sa.EndPointer))...)
+
+	b = append(b, sa.StringData...)
+
+	return b
+}
+
+// GroupData retrieves the compressed contents of a single string group.
+func (sa *StringsArea) GroupData(idx int) ([]byte, error) {
+	if idx < 0 || idx >= len(sa.Pointers) {
+		return nil, wlerr.Errorf(
+			"invalid string group index: have=%d want>=0&&<%d",
+			idx, len(sa.Pointers))
+	}
+
+	start := sa.Pointers[idx] - sa.Pointers[0]
+
+	var end int
+	if idx < len(sa.Pointers)-1 {
+		end = sa.Pointers[idx+1] - sa.Pointers[0]
+	} else {
+		end = len(sa.StringData)
+	}
+
+	return gen.ExtractBlob(sa.StringData, start, end)
+}
@@ -0,0 +1,71 @@
+package decode
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/wlstrings"
+)
+
+func TestEncodeStringsAreaEndPointer(t *testing.T) {
+	sa := StringsArea{
+		CharTable:  make([]byte, StringsCharacterTableLen),
+		Pointers:   []int{4},
+		StringData: make([]byte, 3),
+		EndPointer: 0x1234,
+	}
+
+	// Adding a group shifts the group pointers but not the end pointer.
+	sa.Pointers = append(sa.Pointers, 7)
+	sa.StringData = append(sa.StringData, 0, 0, 0)
+
+	dsa, _, err := DecodeStringsArea(EncodeStringsArea(sa))
+	if err != nil {
+		t.Fatalf("failed to decode strings area: %v", err)
+	}
+	if dsa.EndPointer != sa.EndPointer {
+		t.Fatalf("end pointer changed: have=%#x want=%#x",
+			dsa.EndPointer, sa.EndPointer)
+	}
+	if dsa.Pointers[0] != 6 || dsa.Pointers[1] != 9 {
+		t.Fatalf("group pointers not adjusted: %+v", dsa.Pointers)
+	}
+}
+
+func TestDecodeStringsAreaEnd(t *testing.T) {
+	sa := buildStringsArea(t, [][]byte{
+		[]byte("zero\x00one\x00two\x00three\x00"),
+		[]byte("four\x00five\x00"),
+	})
+	enc := EncodeStringsArea(sa)
+
+	// The final group only holds two strings.  The end pointer marks its end
+	// even though the data that follows decodes as more strings.
+	more, err := wlstrings.CompressStringGroup(sa.CharTable,
+		[]byte("two\x00one\x00"))
+	if err != nil {
+		t.Fatalf("failed to compress string group: %v", err)
+	}
+
+	_, size, err := DecodeStringsArea(append(append([]byte(nil), enc...),
+		more...))
+	if err != nil {
+		t.Fatalf("failed to decode strings area: %v", err)
+	}
+	if size != len(enc) {
+		t.Fatalf("wrong strings area size: have=%d want=%d", size, len(enc))
+	}
+
+	// Without a usable end pointer, undecodable data after the group is not
+	// an error.
+	sa.EndPointer = 0x1234
+	enc = EncodeStringsArea(sa)
+
+	_, size, err = DecodeStringsArea(append(append([]byte(nil), enc...),
+		0xff, 0xff, 0xff, 0xff))
+	if err != nil {
+		t.Fatalf("failed to decode strings area: %v", err)
+	}
+	if size != len(enc) {
+		t.Fatalf("wrong strings area size: have=%d want=%d", size, len(enc))
+	}
+}
@@ -0,0 +1,171 @@
+package decode
+
+import (
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// Action is the result of looking up a tile's action class and selector in a
+// block's action tables.  At most one of the typed pointers is set, according
+// to Class.  For classes without a typed table, Raw contains the element's
+// bytes.  If the selector doesn't refer to an element, nothing is set (see
+// None).
+//
+// Class 6 is shared by shops and special actions.  An element of table 6 that
+// decodes as a shop is reported in Shop.  Otherwise, if the block has special
+// actions, the tile is taken to run one of them: SpecialActions points to the
+// block's special actions and Raw contains the table element.
+// XXX: How a table 6 element selects its entry point in the special actions
+// area is unknown, so the whole area is reported.
+type Action struct {
+	Class    int
+	Selector int
+
+	Print      *action.Print
+	Check      *action.Check
+	Password   *action.Password
+	Alteration *action.Alteration
+	Loot       *action.Loot
+	Shop       *action.Shop
+	Impassable *action.Impassable
+	Transition *action.Transition
+	Dialogue   *action.Dialogue
+	Encounter  *action.Encounter
+
+	SpecialActions *SpecialActions
+	Raw            []byte
+}
+
+// None indicates whether the action refers to nothing, i.e., stepping on the
+// tile has no effect.
+func (a *Action) None() bool {
+	return a.Print == nil && a.Check == nil && a.Password == nil &&
+		a.Alteration == nil && a.Loot == nil && a.Shop == nil &&
+		a.Impassable == nil && a.Transition == nil && a.Dialogue == nil &&
+		a.Encounter == nil && a.SpecialActions == nil && a.Raw == nil
+}
+
+// rawTable retrieves one of the action tables that doesn't have a typed
+// decoder.  It returns nil if the table at idx is typed.
+func rawTable(tables action.Tables, idx int) *gen.Table {
+	switch idx {
+	case 0:
+		return &tables.T0
+	case 8:
+		return &tables.T8
+	case 9:
+		return &tables.T9
+	case 12:
+		return &tables.T12
+	case 14:
+		return &tables.T14
+	case 15:
+		return &tables.T15
+	default:
+		return nil
+	}
+}
+
+// ActionFor looks up the action with the given class and selector.
+func (b *Block) ActionFor(class int, selector int) *Action {
+	a := &Action{
+		Class:    class,
+		Selector: selector,
+	}
+
+	t := &b.ActionTables
+	inRange := func(n int) bool {
+		return selector >= 0 && selector < n
+	}
+
+	switch class {
+	case action.IDPrint:
+		if inRange(len(t.Prints)) {
+			a.Print = t.Prints[selector]
+		}
+	case action.IDCheck:
+		if inRange(len(t.Checks)) {
+			a.Check = t.Checks[selector]
+		}
+	case action.IDPassword:
+		if inRange(len(t.Passwords)) {
+			a.Password = t.Passwords[selector]
+		}
+	case action.IDAlteration:
+		if inRange(len(t.Alterations)) {
+			a.Alteration = t.Alterations[selector]
+		}
+	case action.IDLoot:
+		if inRange(len(t.Loots)) {
+			a.Loot = t.Loots[selector]
+		}
+	case action.IDShop:
+		if inRange(len(t.Shops)) {
+			a.Shop = t.Shops[selector]
+			if a.Shop != nil && a.Shop.Raw != nil &&
+				len(b.SpecialActions.Actions) > 0 {
+
+				a.SpecialActions = &b.SpecialActions
+				a.Raw = a.Shop.Raw
+				a.Shop = nil
+			}
+		}
+	case action.IDImpassable:
+		if inRange(len(t.Impassables)) {
+			a.Impassable = t.Impassables[selector]
+		}
+	case action.IDTransition:
+		if inRange(len(t.Transitions)) {
+			a.Transition = t.Transitions[selector]
+		}
+	case action.IDDialogue:
+		if inRange(len(t.Dialogues)) {
+			a.Dialogue = t.Dialogues[selector]
+		}
+	case action.IDEncounter:
+		if inRange(len(t.Encounters)) {
+			a.Encounter = t.Encounters[selector]
+		}
+	default:
+		if rt := rawTable(*t, class); rt != nil && inRange(len(rt.Elems)) {
+			if len(rt.Elems[selector]) > 0 {
+				a.Raw = rt.Elems[selector]
+			}
+		}
+	}
+
+	return a
+}
+
+// ActionAt determines what happens when the party steps on the specified
+// tile.
+func (b *Block) ActionAt(p gen.Point) (*Action, error) {
+	md := &b.MapData
+	if p.Y < 0 || p.Y >= len(md.ActionClasses) ||
+		p.X < 0 || p.X >= len(md.ActionClasses[p.Y]) {
+
+		return nil, wlerr.Errorf("tile out of bounds: have=%+v dim=%+v",
+			p, b.Dim)
+	}
+
+	return b.ActionFor(md.ActionClasses[p.Y][p.X],
+		md.ActionSelectors[p.Y][p.X]), nil
+}
+
+// TilesUsing lists every tile whose action has the specified class and
+// selector.  It is the inverse of ActionAt.
+func (b *Block) TilesUsing(class int, selector int) []gen.Point {
+	var ps []gen.Point
+
+	md := &b.MapData
+	for y, row := range md.ActionClasses {
+		for x, c := range row {
+			if c == class && md.ActionSelectors[y][x] == selector {
+				ps = append(ps, gen.Point{X: x, Y: y})
+			}
+		}
+	}
+
+	return ps
+}
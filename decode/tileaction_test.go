@@ -0,0 +1,230 @@
+package decode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/gen"
+)
+
+func TestActionAt(t *testing.T) {
+	loot := &action.Loot{ToClass: 0}
+	b := Block{
+		Dim: gen.Point{X: 2, Y: 2},
+		MapData: MapData{
+			ActionClasses:   [][]int{{0, 5}, {5, 0}},
+			ActionSelectors: [][]int{{0, 1}, {1, 0}},
+		},
+		ActionTables: action.Tables{
+			Loots: []*action.Loot{nil, loot},
+		},
+	}
+
+	a, err := b.ActionAt(gen.Point{X: 1, Y: 0})
+	if err != nil {
+		t.Fatalf("failed to get action: %v", err)
+	}
+	if a.Loot != loot || a.None() {
+		t.Fatalf("wrong action: %+v", *a)
+	}
+
+	a, err = b.ActionAt(gen.Point{X: 0, Y: 0})
+	if err != nil {
+		t.Fatalf("failed to get action: %v", err)
+	}
+	if !a.None() {
+		t.Fatalf("empty tile has action: %+v", *a)
+	}
+
+	if _, err := b.ActionAt(gen.Point{X: 2, Y: 0}); err == nil {
+		t.Fatalf("out of bounds tile returned action")
+	}
+
+	ps := b.TilesUsing(action.IDLoot, 1)
+	if len(ps) != 2 || ps[0] != (gen.Point{X: 1, Y: 0}) ||
+		ps[1] != (gen.Point{X: 0, Y: 1}) {
+
+		t.Fatalf("wrong tiles: %+v", ps)
+	}
+}
+
+func TestChainFrom(t *testing.T) {
+	b := Block{
+		ActionTables: action.Tables{
+			Checks: []*action.Check{
+				{PassClass: action.IDLoot, PassSelector: 0, FailClass: 2},
+			},
+			Loots: []*action.Loot{
+				{ToClass: 0, ToSelector: 0},
+				{ToClass: action.IDLoot, ToSelector: 1},
+			},
+		},
+	}
+
+	c := b.ChainFrom(action.IDCheck, 0)
+	if len(c.Actions) != 3 || c.CycleIdx != -1 {
+		t.Fatalf("wrong chain: len=%d cycle=%d", len(c.Actions), c.CycleIdx)
+	}
+	if c.Actions[1].Loot != b.ActionTables.Loots[0] || !c.Actions[2].None() {
+		t.Fatalf("wrong chain contents: %+v", c.Actions)
+	}
+
+	c = b.ChainFrom(action.IDLoot, 1)
+	if len(c.Actions) != 1 || c.CycleIdx != 0 {
+		t.Fatalf("cycle not detected: len=%d cycle=%d",
+			len(c.Actions), c.CycleIdx)
+	}
+}
+
+func TestCompactActions(t *testing.T) {
+	b := Block{
+		MapData: MapData{
+			ActionClasses:   [][]int{{5, 0}},
+			ActionSelectors: [][]int{{2, 0}},
+		},
+		ActionTables: action.Tables{
+			Loots: []*action.Loot{
+				{ToClass: 0},                            // Dead.
+				nil,                                     // Dead.
+				{ToClass: action.IDLoot, ToSelector: 3}, // Used by tile.
+				{ToClass: 0},                            // Linked from 2.
+			},
+		},
+	}
+
+	dead := b.UnreferencedActions()
+	if len(dead) != 1 || dead[0] != (Link{Class: action.IDLoot}) {
+		t.Fatalf("wrong dead entries: %+v", dead)
+	}
+
+	remap, skipped := b.CompactActions()
+	if len(skipped) != 0 {
+		t.Fatalf("classes unexpectedly skipped: %v", skipped)
+	}
+
+	// The null element survives; only the dead loot is removed.
+	loots := b.ActionTables.Loots
+	if len(loots) != 3 || loots[0] != nil {
+		t.Fatalf("wrong loots after compaction: %+v", loots)
+	}
+	if b.MapData.ActionSelectors[0][0] != 1 || loots[1].ToSelector != 2 {
+		t.Fatalf("selectors not renumbered: tile=%d link=%d",
+			b.MapData.ActionSelectors[0][0], loots[1].ToSelector)
+	}
+	if remap[Link{Class: action.IDLoot, Selector: 3}] !=
+		(Link{Class: action.IDLoot, Selector: 2}) {
+
+		t.Fatalf("wrong remap: %+v", remap)
+	}
+}
+
+func TestCompactActionsSkipsRaw(t *testing.T) {
+	newBlock := func() Block {
+		return Block{
+			MapData: MapData{
+				ActionClasses:   [][]int{{action.IDPrint, action.IDLoot}},
+				ActionSelectors: [][]int{{1, 1}},
+			},
+			ActionTables: action.Tables{
+				Prints: []*action.Print{
+					{ToClass: 0},
+					{ToClass: 0},
+				},
+				Loots: []*action.Loot{
+					{ToClass: 0},
+					{ToClass: 0},
+				},
+			},
+		}
+	}
+
+	// checkLoots verifies that the loot table was compacted.
+	checkLoots := func(b Block) {
+		if len(b.ActionTables.Loots) != 1 ||
+			b.MapData.ActionSelectors[0][1] != 0 {
+
+			t.Fatalf("loots not compacted: loots=%d selector=%d",
+				len(b.ActionTables.Loots), b.MapData.ActionSelectors[0][1])
+		}
+	}
+
+	b := newBlock()
+	b.ActionTables.Prints[0].Raw = []byte{0x01}
+	_, skipped := b.CompactActions()
+	if len(skipped) != 1 || skipped[0] != action.IDPrint {
+		t.Fatalf("wrong skipped classes: %v", skipped)
+	}
+	if len(b.ActionTables.Prints) != 2 || b.MapData.ActionSelectors[0][0] != 1 {
+		t.Fatalf("table with raw element compacted")
+	}
+	checkLoots(b)
+
+	b = newBlock()
+	b.ActionTables.T8.Elems = [][]byte{{0x01}, {0x02}}
+	_, skipped = b.CompactActions()
+	if len(skipped) != 1 || skipped[0] != 8 {
+		t.Fatalf("wrong skipped classes: %v", skipped)
+	}
+	if len(b.ActionTables.T8.Elems) != 2 {
+		t.Fatalf("untyped table compacted")
+	}
+	if len(b.ActionTables.Prints) != 1 {
+		t.Fatalf("prints not compacted: prints=%d", len(b.ActionTables.Prints))
+	}
+	checkLoots(b)
+
+	b = newBlock()
+	b.SpecialActions.Actions = []byte{0x01}
+	b.ActionTables.Shops = []*action.Shop{{Raw: []byte{0x01}}}
+	_, skipped = b.CompactActions()
+	if len(skipped) != 1 || skipped[0] != action.IDShop {
+		t.Fatalf("wrong skipped classes: %v", skipped)
+	}
+	if len(b.ActionTables.Shops) != 1 {
+		t.Fatalf("shop table compacted despite special actions")
+	}
+	checkLoots(b)
+}
+
+func TestActionAtSpecial(t *testing.T) {
+	shop := &action.Shop{Items: []action.ShopItem{{ID: 0x1c, Stock: 1}}}
+	special := &action.Shop{Raw: []byte{0x12, 0x34}}
+
+	b := Block{
+		Dim: gen.Point{X: 2, Y: 1},
+		MapData: MapData{
+			ActionClasses:   [][]int{{6, 6}},
+			ActionSelectors: [][]int{{0, 1}},
+		},
+		ActionTables: action.Tables{
+			Shops: []*action.Shop{shop, special},
+		},
+		SpecialActions: SpecialActions{Actions: []byte{0x01, 0x02}},
+	}
+
+	a, err := b.ActionAt(gen.Point{X: 0, Y: 0})
+	if err != nil {
+		t.Fatalf("failed to get action: %v", err)
+	}
+	if a.Shop != shop || a.SpecialActions != nil {
+		t.Fatalf("shop tile not reported as shop: %+v", *a)
+	}
+
+	a, err = b.ActionAt(gen.Point{X: 1, Y: 0})
+	if err != nil {
+		t.Fatalf("failed to get action: %v", err)
+	}
+	if a.Shop != nil || a.SpecialActions != &b.SpecialActions ||
+		!bytes.Equal(a.Raw, special.Raw) || a.None() {
+
+		t.Fatalf("special tile not reported as special action: %+v", *a)
+	}
+
+	// Without special actions, the element can only be a shop.
+	b.SpecialActions = SpecialActions{}
+	a = b.ActionFor(action.IDShop, 1)
+	if a.Shop != special || a.SpecialActions != nil {
+		t.Fatalf("raw shop reported as special action: %+v", *a)
+	}
+}
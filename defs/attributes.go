@@ -0,0 +1,21 @@
+package defs
+
+const (
+	AttributeIDStrength  = 0x00
+	AttributeIDIQ        = 0x01
+	AttributeIDLuck      = 0x02
+	AttributeIDSpeed     = 0x03
+	AttributeIDAgility   = 0x04
+	AttributeIDDexterity = 0x05
+	AttributeIDCharisma  = 0x06
+)
+
+var AttributeNames = []string{
+	AttributeIDStrength:  "Strength",
+	AttributeIDIQ:        "IQ",
+	AttributeIDLuck:      "Luck",
+	AttributeIDSpeed:     "Speed",
+	AttributeIDAgility:   "Agility",
+	AttributeIDDexterity: "Dexterity",
+	AttributeIDCharisma:  "Charisma",
+}
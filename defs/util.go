@@ -55,3 +55,39 @@ func BlockZIPToLoc(bz BlockZIP) (int, error) {
 
 	return 0, wlerr.Errorf("invalid block zip: %+v", bz)
 }
+
+// ItemString produces a string representation of an item ID.
+func ItemString(itemID int) string {
+	var s string
+	if itemID >= 0 && itemID < len(ItemNames) {
+		s = ItemNames[itemID]
+	}
+	if s == "" {
+		s = "???"
+	}
+	return s
+}
+
+// SkillString produces a string representation of a skill ID.
+func SkillString(skillID int) string {
+	var s string
+	if skillID > 0 && skillID < len(SkillNames) {
+		s = SkillNames[skillID]
+	}
+	if s == "" {
+		s = "???"
+	}
+	return s
+}
+
+// AttributeString produces a string representation of an attribute ID.
+func AttributeString(attrID int) string {
+	var s string
+	if attrID >= 0 && attrID < len(AttributeNames) {
+		s = AttributeNames[attrID]
+	}
+	if s == "" {
+		s = "???"
+	}
+	return s
+}
@@ -0,0 +1,346 @@
+// Package diff compares decoded games field by field.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+)
+
+// Kind indicates how a value differs between two decode states.
+type Kind string
+
+const (
+	KindChanged Kind = "changed" // Value present in both; contents differ.
+	KindAdded   Kind = "added"   // Slice element only in the new state.
+	KindRemoved Kind = "removed" // Slice element only in the old state.
+)
+
+// SectionSaveState is the section name of changes to the save state.  Such
+// changes have a block index of BlockSaveState.
+const (
+	SectionSaveState = "SaveState"
+	BlockSaveState   = -1
+)
+
+// Change is a single difference between two decode states.
+//
+// Section is the name of a decode.Block field (e.g., "NPCTable") or
+// SectionSaveState.  Path locates the value within the section using field
+// names and slice indices (e.g., "NPCs[2].Strength"); it is empty if the
+// whole section differs.  KindAdded and KindRemoved refer to slice elements
+// (or whole blocks); a pointer that becomes nil or non-nil is KindChanged.
+// For KindAdded, Old is nil; for KindRemoved, New is nil.
+type Change struct {
+	Game    int         `json:"game"`  // 0=GAME1, 1=GAME2
+	Block   int         `json:"block"` // Or BlockSaveState.
+	Section string      `json:"section"`
+	Path    string      `json:"path"`
+	Kind    Kind        `json:"kind"`
+	Old     interface{} `json:"old,omitempty"`
+	New     interface{} `json:"new,omitempty"`
+}
+
+// derivedFields are decode.Block fields that describe a block's layout rather
+// than its contents.  They change as a side effect of other changes, so they
+// are not compared.  The central directory is recalculated whenever the areas
+// it points to are written back (see wlutil.CommitDecodeState), so changes to
+// it would only undo the relocation of the areas.
+var derivedFields = map[string]bool{
+	"Dim":        true,
+	"Offsets":    true,
+	"Sizes":      true,
+	"CentralDir": true,
+}
+
+// formatValue converts a changed value to a short human readable string.
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "nil"
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return "nil"
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+		return fmt.Sprintf("%x", rv.Bytes())
+	}
+
+	return fmt.Sprintf("%+v", rv.Interface())
+}
+
+// String converts a change to a single line of text, e.g.,
+// "GAME1 block 9 NPCTable NPCs[2].Strength: 12 -> 15".
+func (c Change) String() string {
+	loc := fmt.Sprintf("GAME%d", c.Game+1)
+	if c.Block != BlockSaveState {
+		loc += fmt.Sprintf(" block %d", c.Block)
+	}
+	if c.Section != "" {
+		loc += " " + c.Section
+	}
+	if c.Path != "" {
+		loc += " " + c.Path
+	}
+
+	switch c.Kind {
+	case KindAdded:
+		return fmt.Sprintf("%s: added %s", loc, formatValue(c.New))
+	case KindRemoved:
+		return fmt.Sprintf("%s: removed %s", loc, formatValue(c.Old))
+	default:
+		return fmt.Sprintf("%s: %s -> %s",
+			loc, formatValue(c.Old), formatValue(c.New))
+	}
+}
+
+// walker accumulates the differences between two values.
+type walker struct {
+	base    Change // Location of the values being compared.
+	changes []Change
+}
+
+func (w *walker) add(path string, kind Kind, oldVal interface{},
+	newVal interface{}) {
+
+	c := w.base
+	c.Path = path
+	c.Kind = kind
+	c.Old = oldVal
+	c.New = newVal
+
+	w.changes = append(w.changes, c)
+}
+
+func joinField(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func joinIndex(path string, idx int) string {
+	return path + "[" + strconv.Itoa(idx) + "]"
+}
+
+// rawEncoders re-encode the decoded types that keep their encoded form in a
+// field named Raw.  Each takes a value of the type (not a pointer).
+var rawEncoders = map[reflect.Type]func(v interface{}) ([]byte, error){
+	reflect.TypeOf(decode.Character{}): func(v interface{}) ([]byte, error) {
+		return decode.EncodeCharacter(v.(decode.Character))
+	},
+	reflect.TypeOf(decode.SaveState{}): func(v interface{}) ([]byte, error) {
+		return decode.EncodeSaveState(v.(decode.SaveState))
+	},
+	reflect.TypeOf(action.Print{}): func(v interface{}) ([]byte, error) {
+		return action.EncodePrint(v.(action.Print)), nil
+	},
+	reflect.TypeOf(action.Check{}): func(v interface{}) ([]byte, error) {
+		return action.EncodeCheck(v.(action.Check)), nil
+	},
+	reflect.TypeOf(action.Password{}): func(v interface{}) ([]byte, error) {
+		return action.EncodePassword(v.(action.Password)), nil
+	},
+	reflect.TypeOf(action.Alteration{}): func(v interface{}) ([]byte, error) {
+		return action.EncodeAlteration(v.(action.Alteration)), nil
+	},
+	reflect.TypeOf(action.Shop{}): func(v interface{}) ([]byte, error) {
+		return action.EncodeShop(v.(action.Shop)), nil
+	},
+	reflect.TypeOf(action.Impassable{}): func(v interface{}) ([]byte, error) {
+		return action.EncodeImpassable(v.(action.Impassable)), nil
+	},
+	reflect.TypeOf(action.Dialogue{}): func(v interface{}) ([]byte, error) {
+		return action.EncodeDialogue(v.(action.Dialogue)), nil
+	},
+	reflect.TypeOf(action.Encounter{}): func(v interface{}) ([]byte, error) {
+		return action.EncodeEncounter(v.(action.Encounter)), nil
+	},
+}
+
+// rawFollows indicates whether b's Raw field is exactly what re-encoding b's
+// other fields on top of a's Raw produces.  If so, the Raw change is a side
+// effect of the other changes.  rawIdx is the index of the Raw field.  It
+// returns false for types without an entry in rawEncoders.
+func rawFollows(a reflect.Value, b reflect.Value, rawIdx int) bool {
+	encode := rawEncoders[a.Type()]
+	if encode == nil {
+		return false
+	}
+
+	v := reflect.New(a.Type()).Elem()
+	v.Set(b)
+	v.Field(rawIdx).Set(a.Field(rawIdx))
+
+	enc, err := encode(v.Interface())
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(enc, b.Field(rawIdx).Bytes())
+}
+
+// walkStruct compares two structs of the same type.  Decoded types keep a copy
+// of their encoded form in a field named Raw.  A change to Raw is not reported
+// if it is fully explained by changes to the struct's other fields (see
+// rawFollows); it would otherwise duplicate every other change.
+func (w *walker) walkStruct(path string, a reflect.Value, b reflect.Value) {
+	t := a.Type()
+	numChanges := len(w.changes)
+	rawIdx := -1
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		if f.Name == "Raw" {
+			rawIdx = i
+			continue
+		}
+
+		w.walk(joinField(path, f.Name), a.Field(i), b.Field(i))
+	}
+
+	if rawIdx >= 0 &&
+		(len(w.changes) == numChanges || !rawFollows(a, b, rawIdx)) {
+
+		w.walk(joinField(path, t.Field(rawIdx).Name),
+			a.Field(rawIdx), b.Field(rawIdx))
+	}
+}
+
+// walk compares two values of the same type.  Byte slices are compared as
+// single values; other slices and arrays are compared element by element.
+func (w *walker) walk(path string, a reflect.Value, b reflect.Value) {
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() && b.IsNil() {
+			return
+		}
+		if a.IsNil() || b.IsNil() {
+			w.add(path, KindChanged, a.Interface(), b.Interface())
+			return
+		}
+		w.walk(path, a.Elem(), b.Elem())
+
+	case reflect.Struct:
+		w.walkStruct(path, a, b)
+
+	case reflect.Slice, reflect.Array:
+		if a.Type().Elem().Kind() == reflect.Uint8 {
+			if !reflect.DeepEqual(a.Interface(), b.Interface()) &&
+				(a.Len() != 0 || b.Len() != 0) {
+
+				w.add(path, KindChanged, a.Interface(), b.Interface())
+			}
+			return
+		}
+
+		for i := 0; i < a.Len() && i < b.Len(); i++ {
+			w.walk(joinIndex(path, i), a.Index(i), b.Index(i))
+		}
+		for i := b.Len(); i < a.Len(); i++ {
+			w.add(joinIndex(path, i), KindRemoved, a.Index(i).Interface(), nil)
+		}
+		for i := a.Len(); i < b.Len(); i++ {
+			w.add(joinIndex(path, i), KindAdded, nil, b.Index(i).Interface())
+		}
+
+	default:
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			w.add(path, KindChanged, a.Interface(), b.Interface())
+		}
+	}
+}
+
+// DiffBlocks lists the differences between two decoded blocks.  gameIdx and
+// blockIdx identify the block in the changes.
+func DiffBlocks(gameIdx int, blockIdx int, a decode.Block,
+	b decode.Block) []Change {
+
+	w := &walker{}
+
+	av := reflect.ValueOf(a)
+	bv := reflect.ValueOf(b)
+	t := av.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if derivedFields[name] {
+			continue
+		}
+
+		w.base = Change{
+			Game:    gameIdx,
+			Block:   blockIdx,
+			Section: name,
+		}
+		w.walk("", av.Field(i), bv.Field(i))
+	}
+
+	return w.changes
+}
+
+// DiffSaveStates lists the differences between two save states.  Either may
+// be nil.
+func DiffSaveStates(a *decode.SaveState, b *decode.SaveState) []Change {
+	w := &walker{
+		base: Change{
+			Game:    0,
+			Block:   BlockSaveState,
+			Section: SectionSaveState,
+		},
+	}
+	w.walk("", reflect.ValueOf(a), reflect.ValueOf(b))
+
+	return w.changes
+}
+
+// DiffStates lists the differences between two decode states.  Block changes
+// are ordered by game, then block, then section, then position within the
+// section, so the output is stable.  Save state changes come last.  A block
+// that is only present in one of the states is reported as a single change
+// with an empty section.
+func DiffStates(a decode.DecodeState, b decode.DecodeState) []Change {
+	var changes []Change
+
+	for g := 0; g < len(a.Blocks) || g < len(b.Blocks); g++ {
+		var abs, bbs []decode.Block
+		if g < len(a.Blocks) {
+			abs = a.Blocks[g]
+		}
+		if g < len(b.Blocks) {
+			bbs = b.Blocks[g]
+		}
+
+		for i := 0; i < len(abs) || i < len(bbs); i++ {
+			switch {
+			case i >= len(bbs):
+				changes = append(changes, Change{
+					Game: g, Block: i, Kind: KindRemoved, Old: abs[i],
+				})
+			case i >= len(abs):
+				changes = append(changes, Change{
+					Game: g, Block: i, Kind: KindAdded, New: bbs[i],
+				})
+			default:
+				changes = append(changes, DiffBlocks(g, i, abs[i], bbs[i])...)
+			}
+		}
+	}
+
+	changes = append(changes, DiffSaveStates(a.SaveState, b.SaveState)...)
+
+	return changes
+}
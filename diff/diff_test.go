@@ -0,0 +1,109 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+)
+
+// testCharacter produces a character whose Raw field holds a real 256-byte
+// image.
+func testCharacter(t *testing.T) decode.Character {
+	img, err := decode.EncodeCharacter(decode.Character{Name: "Bob", Strength: 12})
+	if err != nil {
+		t.Fatalf("failed to encode character: %v", err)
+	}
+
+	ch, err := decode.DecodeCharacter(img)
+	if err != nil {
+		t.Fatalf("failed to decode character: %v", err)
+	}
+
+	return *ch
+}
+
+// reencode updates a character's Raw field to match its other fields.
+func reencode(t *testing.T, ch *decode.Character) {
+	img, err := decode.EncodeCharacter(*ch)
+	if err != nil {
+		t.Fatalf("failed to encode character: %v", err)
+	}
+	ch.Raw = img
+}
+
+func TestDiffStates(t *testing.T) {
+	makeState := func() decode.DecodeState {
+		return decode.DecodeState{
+			Blocks: [][]decode.Block{
+				{
+					decode.Block{
+						NPCTable: decode.NPCTable{
+							NPCs: []decode.Character{
+								testCharacter(t),
+							},
+						},
+						ActionTables: action.Tables{
+							Loots: []*action.Loot{
+								&action.Loot{
+									Items: []action.LootItem{{ID: 1}},
+								},
+							},
+						},
+					},
+				},
+				nil,
+			},
+		}
+	}
+
+	a := makeState()
+	b := makeState()
+	// The Raw change follows from the strength change, so it isn't listed.
+	b.Blocks[0][0].NPCTable.NPCs[0].Strength = 15
+	reencode(t, &b.Blocks[0][0].NPCTable.NPCs[0])
+	b.Blocks[0][0].ActionTables.Loots[0].Items = append(
+		b.Blocks[0][0].ActionTables.Loots[0].Items, action.LootItem{ID: 0x1c})
+
+	changes := DiffStates(a, b)
+
+	want := []string{
+		"GAME1 block 0 ActionTables Loots[0].Items[1]: added {Fixed:false ID:28 Amount:0}",
+		"GAME1 block 0 NPCTable NPCs[0].Strength: 12 -> 15",
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("wrong number of changes: have=%d want=%d: %+v",
+			len(changes), len(want), changes)
+	}
+	for i, c := range changes {
+		if c.String() != want[i] {
+			t.Errorf("wrong change: have=%q want=%q", c.String(), want[i])
+		}
+	}
+
+	if len(DiffStates(a, makeState())) != 0 {
+		t.Errorf("identical states differ")
+	}
+}
+
+func TestDiffRawIndependent(t *testing.T) {
+	a := testCharacter(t)
+	b := testCharacter(t)
+
+	// Change a byte that no decoded field covers along with a decoded field.
+	b.Strength = 15
+	reencode(t, &b)
+	b.Raw = append([]byte(nil), b.Raw...)
+	b.Raw[0xff] ^= 0x01
+
+	changes := DiffBlocks(0, 0,
+		decode.Block{NPCTable: decode.NPCTable{NPCs: []decode.Character{a}}},
+		decode.Block{NPCTable: decode.NPCTable{NPCs: []decode.Character{b}}})
+
+	if len(changes) != 2 ||
+		changes[0].Path != "NPCs[0].Strength" ||
+		changes[1].Path != "NPCs[0].Raw" {
+
+		t.Fatalf("independent raw change not reported: %+v", changes)
+	}
+}
@@ -5,13 +5,15 @@ import (
 	"strings"
 
 	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
 	"github.com/badvassal/wllib/wlstrings"
 )
 
 // Monster is an element of monster data with some user friendly annotations.
 type Monster struct {
-	Name string
-	Elem decode.MonsterDataElem
+	Name   string
+	Plural string
+	Elem   decode.MonsterDataElem
 }
 
 // MonsterNameSingular calculates the singular form of a monster name.
@@ -19,24 +21,61 @@ func MonsterNameSingular(name decode.MonsterName) string {
 	return name.Start + name.MidSingular + name.End
 }
 
+// MonsterNamePlural calculates the plural form of a monster name.
+func MonsterNamePlural(name decode.MonsterName) string {
+	return name.Start + name.MidPlural + name.End
+}
+
+// EncounterMonster resolves the monster that an encounter refers to.
+func EncounterMonster(b decode.Block, e action.Encounter) (*Monster, error) {
+	if e.Raw != nil {
+		return nil, fmt.Errorf("encounter was not decoded")
+	}
+
+	idx := e.MonsterIdx
+	if idx >= len(b.MonsterData.Monsters) || idx >= len(b.MonsterNames.Names) {
+		return nil, fmt.Errorf(
+			"encounter refers to invalid monster: have=%d want<%d&&<%d",
+			idx, len(b.MonsterData.Monsters), len(b.MonsterNames.Names))
+	}
+
+	name := b.MonsterNames.Names[idx]
+	return &Monster{
+		Name:   MonsterNameSingular(name),
+		Plural: MonsterNamePlural(name),
+		Elem:   b.MonsterData.Monsters[idx],
+	}, nil
+}
+
+// EncounterString produces a plain English description of an encounter,
+// e.g., "2-4 rats".
+func EncounterString(b decode.Block, e action.Encounter) (string, error) {
+	m, err := EncounterMonster(b, e)
+	if err != nil {
+		return "", err
+	}
+
+	name := m.Plural
+	if e.MaxCount == 1 {
+		name = m.Name
+	}
+
+	if e.Fixed || e.MinCount == e.MaxCount {
+		return fmt.Sprintf("%d %s", e.MaxCount, name), nil
+	}
+
+	return fmt.Sprintf("%d-%d %s", e.MinCount, e.MaxCount, name), nil
+}
+
 // DecompressStringsArea decodes a set of compressed strings into ASCII text.
 func DecompressStringsArea(sa decode.StringsArea) ([][]byte, error) {
-	cgs := make([][]byte, len(sa.Pointers))
-	for i, p := range sa.Pointers {
-		start := p - sa.Pointers[0]
-
-		var end int
-		if i < len(sa.Pointers)-1 {
-			end = sa.Pointers[i+1] - sa.Pointers[0]
-		} else {
-			end = len(sa.StringData)
+	dgs := make([][]byte, len(sa.Pointers))
+	for i := range sa.Pointers {
+		cg, err := sa.GroupData(i)
+		if err != nil {
+			return nil, err
 		}
 
-		cgs[i] = sa.StringData[start:end]
-	}
-
-	dgs := make([][]byte, len(cgs))
-	for i, cg := range cgs {
 		dg, err := wlstrings.DecompressStringGroup(sa.CharTable, cg)
 		if err != nil {
 			return nil, err
@@ -48,6 +87,38 @@ func DecompressStringsArea(sa decode.StringsArea) ([][]byte, error) {
 	return dgs, nil
 }
 
+// CompressStringsArea encodes a set of ASCII string groups into a strings
+// area.  It is the inverse of DecompressStringsArea.  A new character table is
+// chosen to suit the given text.
+func CompressStringsArea(groups [][]byte) (*decode.StringsArea, error) {
+	charTable, err := wlstrings.BuildCharTable(groups)
+	if err != nil {
+		return nil, err
+	}
+
+	sa := &decode.StringsArea{
+		CharTable: charTable,
+	}
+
+	// Pointers are relative to the start of the pointer list.  The list ends
+	// with an extra pointer; point it at the end of the string data.
+	ptrAreaSize := (len(groups) + 1) * 2
+
+	for i, g := range groups {
+		cg, err := wlstrings.CompressStringGroup(charTable, g)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress string group %d: %v",
+				i, err)
+		}
+
+		sa.Pointers = append(sa.Pointers, ptrAreaSize+len(sa.StringData))
+		sa.StringData = append(sa.StringData, cg...)
+	}
+	sa.EndPointer = ptrAreaSize + len(sa.StringData)
+
+	return sa, nil
+}
+
 // MapDataString converts an instance of map data into a user friendly string.
 func MapDataString(md decode.MapData) string {
 	var lines []string
@@ -67,3 +138,25 @@ func MapDataString(md decode.MapData) string {
 
 	return strings.Join(lines, "\n")
 }
+
+// NPCJoinString retrieves the message that an NPC says when joining the
+// party.
+func NPCJoinString(strs *decode.Strings, ch decode.Character) (string, error) {
+	return strs.Get(ch.JoinStringIdx)
+}
+
+// MapInfoStrings retrieves every message referenced by a block's map info.
+// Some IDs may be out of range for the block's strings; rather than failing
+// outright, the error for each such ID is returned in the corresponding element
+// of the second slice (nil for IDs that resolved).
+func MapInfoStrings(strs *decode.Strings, mi decode.MapInfo) ([]string,
+	[]error) {
+
+	ss := make([]string, len(mi.StringIDs))
+	errs := make([]error, len(mi.StringIDs))
+	for i, id := range mi.StringIDs {
+		ss[i], errs[i] = strs.Get(id)
+	}
+
+	return ss, errs
+}
@@ -0,0 +1,123 @@
+package digest
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+)
+
+func testStrings(t *testing.T) *decode.Strings {
+	sa, err := CompressStringsArea([][]byte{
+		[]byte("Welcome.\x00Go away!\x00\x00Count me in.\x00"),
+	})
+	if err != nil {
+		t.Fatalf("failed to compress strings area: %v", err)
+	}
+
+	strs, err := decode.DecodeStrings(*sa)
+	if err != nil {
+		t.Fatalf("failed to decode strings: %v", err)
+	}
+
+	return strs
+}
+
+func TestNPCJoinString(t *testing.T) {
+	strs := testStrings(t)
+
+	s, err := NPCJoinString(strs, decode.Character{JoinStringIdx: 3})
+	if err != nil {
+		t.Fatalf("failed to get join string: %v", err)
+	}
+	if s != "Count me in." {
+		t.Fatalf("wrong join string: have=%q want=%q", s, "Count me in.")
+	}
+
+	if _, err := NPCJoinString(strs, decode.Character{JoinStringIdx: 4}); err == nil {
+		t.Fatalf("join string lookup succeeded with invalid ID")
+	}
+}
+
+func TestMapInfoStrings(t *testing.T) {
+	strs := testStrings(t)
+
+	ss, errs := MapInfoStrings(strs, decode.MapInfo{
+		StringIDs: []int{1, 9, 0},
+	})
+
+	want := []string{"Go away!", "", "Welcome."}
+	for i, w := range want {
+		if ss[i] != w {
+			t.Fatalf("wrong string %d: have=%q want=%q", i, ss[i], w)
+		}
+	}
+
+	if errs[0] != nil || errs[2] != nil {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if errs[1] == nil {
+		t.Fatalf("invalid string ID did not produce an error")
+	}
+}
+
+func testEncounterBlock() decode.Block {
+	return decode.Block{
+		MonsterNames: decode.MonsterNames{
+			Names: []decode.MonsterName{
+				{Start: "rat", MidPlural: "s"},
+				{Start: "wol", MidSingular: "f", MidPlural: "ves"},
+			},
+		},
+		MonsterData: decode.MonsterData{
+			Monsters: []decode.MonsterDataElem{
+				{HitPoints: 5},
+				{HitPoints: 12},
+			},
+		},
+	}
+}
+
+func TestEncounterMonster(t *testing.T) {
+	b := testEncounterBlock()
+
+	m, err := EncounterMonster(b, action.Encounter{MonsterIdx: 1})
+	if err != nil {
+		t.Fatalf("failed to resolve monster: %v", err)
+	}
+	if m.Name != "wolf" || m.Plural != "wolves" || m.Elem.HitPoints != 12 {
+		t.Fatalf("wrong monster: %+v", *m)
+	}
+
+	if _, err := EncounterMonster(b, action.Encounter{MonsterIdx: 2}); err == nil {
+		t.Fatalf("invalid monster index resolved without error")
+	}
+	if _, err := EncounterMonster(b, action.Encounter{Raw: []byte{1}}); err == nil {
+		t.Fatalf("raw encounter resolved without error")
+	}
+}
+
+func TestEncounterString(t *testing.T) {
+	b := testEncounterBlock()
+
+	tests := []struct {
+		e    action.Encounter
+		want string
+	}{
+		{action.Encounter{MonsterIdx: 1, MinCount: 2, MaxCount: 4}, "2-4 wolves"},
+		{action.Encounter{MonsterIdx: 1, Fixed: true, MinCount: 1, MaxCount: 3},
+			"3 wolves"},
+		{action.Encounter{MonsterIdx: 1, MinCount: 1, MaxCount: 1}, "1 wolf"},
+		{action.Encounter{MonsterIdx: 0, MinCount: 5, MaxCount: 5}, "5 rats"},
+	}
+
+	for i, test := range tests {
+		s, err := EncounterString(b, test.e)
+		if err != nil {
+			t.Fatalf("failed to describe encounter %d: %v", i, err)
+		}
+		if s != test.want {
+			t.Errorf("wrong description %d: have=%q want=%q", i, s, test.want)
+		}
+	}
+}
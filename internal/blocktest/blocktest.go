@@ -0,0 +1,34 @@
+// Package blocktest builds small decoded MSQ blocks for use in tests.
+package blocktest
+
+import (
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/gen"
+)
+
+// Dim is the size of the map of every block built by this package.
+var Dim = gen.Point{X: 4, Y: 2}
+
+// Block builds a minimal block that can be encoded with serialize.EncodeBlock.
+// It has a 4x2 map, a single monster with 5 hit points and a strings area
+// with zeroed string data.  It has no action tables or NPCs; tests add
+// whatever they need.
+func Block() decode.Block {
+	return decode.Block{
+		Dim: Dim,
+		MapData: decode.MapData{
+			ActionClasses:   [][]int{{5, 10, 0, 0}, {0, 0, 0, 0}},
+			ActionSelectors: [][]int{{0, 0, 0, 0}, {0, 0, 0, 0}},
+		},
+		MapInfo: decode.MapInfo{StringIDs: make([]int, 18)},
+		MonsterData: decode.MonsterData{
+			Monsters: []decode.MonsterDataElem{{HitPoints: 5}},
+		},
+		StringsArea: decode.StringsArea{
+			CharTable:  make([]byte, decode.StringsCharacterTableLen),
+			Pointers:   []int{4},
+			StringData: make([]byte, 3),
+			EndPointer: 7,
+		},
+	}
+}
@@ -1,14 +1,10 @@
 package modify
 
 import (
-	"fmt"
-
 	"github.com/badvassal/wllib/decode"
 	"github.com/badvassal/wllib/decode/action"
 	"github.com/badvassal/wllib/gen"
-	"github.com/badvassal/wllib/gen/wlerr"
 	"github.com/badvassal/wllib/msq"
-	"github.com/badvassal/wllib/serialize"
 )
 
 // BlockModifier is used for modifying an MSQ block body.
@@ -24,6 +20,11 @@ import (
 // padding bytes).  Resizing blocks leads Wasteland to report a data corruption
 // error on startup.  If we can ever make block resizing work, then we can
 // remove this type (and indeed this entire file).
+//
+// Several replacements can be applied together with a transaction (see
+// Begin).  The modifier never changes the size of a block.  A replacement
+// that is larger than the original only succeeds if the block contains enough
+// unused zero bytes (slack) for neighbouring areas to be moved out of the way.
 type BlockModifier struct {
 	body msq.Body
 	dim  gen.Point
@@ -36,194 +37,110 @@ func NewBlockModifier(body msq.Body, dim gen.Point) *BlockModifier {
 	}
 }
 
-// offsetPair converts an absolute MSQ block body offset to a lower level
-// representation.  It returns true if the offset can be found in the secure
-// section of the block; false otherwise.  The second return value is the
-// offset within the relevant section.
-func (m *BlockModifier) offsetPair(off int) (bool, int, error) {
-	if off < len(m.body.SecSection) {
-		return true, off, nil
-	}
-
-	plainOff := off - len(m.body.SecSection)
-	if plainOff < len(m.body.PlainSection) {
-		return false, plainOff, nil
-	}
-
-	return false, 0, fmt.Errorf(
-		"invalid block body offset: have=%d want<%d",
-		off, len(m.body.SecSection)+len(m.body.PlainSection))
-}
-
-// writeBytesToBuf copies data from one byte slice to another at a specified
-// destination offset.  If the data would extend beyond the end of the
-// destination slice, the slice is extended to accommodate the source data.
-func writeBytesToBuf(src []byte, dst *[]byte, off int) {
-	end := off + len(src)
-
-	extra := end - len(*dst)
-	if extra > 0 {
-		*dst = append(*dst, make([]byte, extra)...)
-	}
-
-	copy((*dst)[off:off+len(src)], src)
-}
-
-// writeBytes writes bytes to the MSQ block body at the specified offset.
-func (m *BlockModifier) writeBytes(data []byte, off int) error {
-	isEnc, off, err := m.offsetPair(off)
-	if err != nil {
-		return err
-	}
-
-	var dest *[]byte
-	if isEnc {
-		dest = &m.body.SecSection
-	} else {
-		dest = &m.body.PlainSection
-	}
-
-	writeBytesToBuf(data, dest, off)
+// apply queues replacements in a new transaction and commits it.
+func (m *BlockModifier) apply(queue func(tx *Tx)) error {
+	tx := m.Begin()
+	queue(tx)
 
-	return nil
+	_, err := tx.Commit()
+	return err
 }
 
 // ReplaceMapInfo replaces an MSQ block's map info section with the specified
 // one.
 func (m *BlockModifier) ReplaceMapInfo(mi decode.MapInfo) error {
-	decBlock, err := decode.DecodeBlock(m.body, m.dim)
-	if err != nil {
-		return err
-	}
-
-	smi := decode.EncodeMapInfo(mi)
-	if len(smi) != decBlock.Sizes.MapInfo {
-		return fmt.Errorf("map infos differ in size: old=%d new=%d",
-			decBlock.Sizes.MapInfo, len(smi))
-	}
-
-	if err := m.writeBytes(smi, decBlock.Offsets.MapInfo); err != nil {
-		return err
-	}
-
-	return nil
+	return m.apply(func(tx *Tx) { tx.ReplaceMapInfo(mi) })
 }
 
 // ReplaceMonsterData replaces an MSQ block's monster data section with the
 // specified one.
 func (m *BlockModifier) ReplaceMonsterData(md decode.MonsterData) error {
-	decBlock, err := decode.DecodeBlock(m.body, m.dim)
-	if err != nil {
-		return err
-	}
-
-	smd := decode.EncodeMonsterData(md)
-	if len(smd) != decBlock.Sizes.MonsterData {
-		return fmt.Errorf("monster datas differ in size: old=%d new=%d",
-			decBlock.Sizes.MonsterData, len(smd))
-	}
-
-	if err := m.writeBytes(smd, decBlock.Offsets.MonsterData); err != nil {
-		return err
-	}
-
-	return nil
+	return m.apply(func(tx *Tx) { tx.ReplaceMonsterData(md) })
 }
 
-// ReplaceMonsterData replaces an MSQ block's loot section with the specified
-// one.
+// ReplaceLoots replaces an MSQ block's loot section with the specified one.
+// If the replacement is larger than the original, it is allowed to grow into
+// the block's free space (see ScanLayout).
 func (m *BlockModifier) ReplaceLoots(loots []*action.Loot) error {
-	db, err := decode.DecodeBlock(m.body, m.dim)
-	if err != nil {
-		return err
-	}
-
-	cb, err := decode.CarveBlock(m.body, m.dim)
-	if err != nil {
-		return err
-	}
-
-	st := serialize.SerializeActionLoots(loots, db.CentralDir.ActionTables[action.IDLoot])
-	overflow := len(st) - len(cb.ActionTables[action.IDLoot])
-
-	if overflow != 0 {
-		return wlerr.Errorf(
-			"failed to replace loot table: "+
-				"replacement has size different from original: overflow=%d",
-			overflow)
-	}
-
-	off := db.Offsets.ActionTables[action.IDLoot]
-	copy(m.body.SecSection[off:off+len(st)], st)
+	return m.apply(func(tx *Tx) { tx.ReplaceLoots(loots) })
+}
 
-	return nil
+// ReplaceShops replaces an MSQ block's shop table with the specified one.  If
+// the replacement is larger than the original, it is allowed to grow into the
+// block's free space (see ScanLayout).
+func (m *BlockModifier) ReplaceShops(shops []*action.Shop) error {
+	return m.apply(func(tx *Tx) { tx.ReplaceShops(shops) })
 }
 
 // ReplaceActionTransitions replaces an MSQ block's transitions action table
-// with the specified one.
+// with the specified one.  If the replacement is larger than the original, it
+// is allowed to grow into the block's free space (see ScanLayout).
 func (m *BlockModifier) ReplaceActionTransitions(transitions []*action.Transition) error {
-	db, err := decode.DecodeBlock(m.body, m.dim)
-	if err != nil {
-		return err
-	}
-
-	cb, err := decode.CarveBlock(m.body, m.dim)
-	if err != nil {
-		return err
-	}
-
-	db.ActionTables.Transitions = transitions
-
-	st := serialize.SerializeActionTransitions(transitions,
-		db.CentralDir.ActionTables[action.IDTransition])
-	overflow := len(st) - len(cb.ActionTables[action.IDTransition])
+	return m.apply(func(tx *Tx) { tx.ReplaceActionTransitions(transitions) })
+}
 
-	if overflow != 0 {
-		return wlerr.Errorf(
-			"failed to replace action transitions: "+
-				"replacement has size different from original: overflow=%d",
-			overflow)
-	}
+// ReplaceNPCTable replaces an MSQ block's NPC table with the specified one.
+// If the replacement is larger than the original, it is allowed to grow into
+// the block's free space (see ScanLayout).  It is an error to replace an
+// existing NPC table with an empty one.
+func (m *BlockModifier) ReplaceNPCTable(npcTable decode.NPCTable) error {
+	return m.apply(func(tx *Tx) { tx.ReplaceNPCTable(npcTable) })
+}
 
-	off := db.Offsets.ActionTables[action.IDTransition]
-	copy(m.body.SecSection[off:off+len(st)], st)
+// ReplaceMapData replaces an MSQ block's map data section with the specified
+// one.
+func (m *BlockModifier) ReplaceMapData(md decode.MapData) error {
+	return m.apply(func(tx *Tx) { tx.ReplaceMapData(md) })
+}
 
-	return nil
+// ReplaceCentralDir replaces an MSQ block's central directory with the
+// specified one.  See Tx.ReplaceCentralDir.
+func (m *BlockModifier) ReplaceCentralDir(cd decode.CentralDir) error {
+	return m.apply(func(tx *Tx) { tx.ReplaceCentralDir(cd) })
 }
 
-// ReplaceNPCTable replaces an MSQ block's NPC table with the specified one.
-func (m *BlockModifier) ReplaceNPCTable(npcTable decode.NPCTable) error {
-	if len(npcTable.NPCs) == 0 {
-		return nil
-	}
+// ReplaceMonsterNames replaces an MSQ block's monster names section with the
+// specified one.  If the replacement is larger than the original, it is
+// allowed to grow into the block's free space (see ScanLayout).
+func (m *BlockModifier) ReplaceMonsterNames(mn decode.MonsterNames) error {
+	return m.apply(func(tx *Tx) { tx.ReplaceMonsterNames(mn) })
+}
 
-	db, err := decode.DecodeBlock(m.body, m.dim)
-	if err != nil {
-		return err
-	}
+// ReplaceSpecialActions replaces an MSQ block's special actions section with
+// the specified one.  See Tx.ReplaceSpecialActions.
+func (m *BlockModifier) ReplaceSpecialActions(sa decode.SpecialActions) error {
+	return m.apply(func(tx *Tx) { tx.ReplaceSpecialActions(sa) })
+}
 
-	cb, err := decode.CarveBlock(m.body, m.dim)
-	if err != nil {
-		return err
-	}
+// ReplaceActionTable replaces one of an MSQ block's action tables (0-15) with
+// the corresponding table in tables.  If the replacement is larger than the
+// original, it is allowed to grow into the block's free space (see
+// ScanLayout).
+func (m *BlockModifier) ReplaceActionTable(idx int, tables action.Tables) error {
+	return m.apply(func(tx *Tx) { tx.ReplaceActionTable(idx, tables) })
+}
 
-	en, err := decode.EncodeNPCTable(npcTable, db.CentralDir.NPCTable)
-	if err != nil {
-		return err
-	}
-	overflow := len(en) - len(cb.NPCTable)
+// ReplaceActionTables replaces all of an MSQ block's action tables with the
+// specified ones.
+func (m *BlockModifier) ReplaceActionTables(tables action.Tables) error {
+	return m.apply(func(tx *Tx) { tx.ReplaceActionTables(tables) })
+}
 
-	if overflow != 0 {
-		return wlerr.Errorf(
-			"failed to replace NPC table: "+
-				"replacement has size different from original: overflow=%d",
-			overflow)
-	}
+// ReplaceStringsArea replaces an MSQ block's strings area with the specified
+// one.  See Tx.ReplaceStringsArea.
+func (m *BlockModifier) ReplaceStringsArea(sa decode.StringsArea) error {
+	return m.apply(func(tx *Tx) { tx.ReplaceStringsArea(sa) })
+}
 
-	off := db.Offsets.NPCTable
-	copy(m.body.SecSection[off:off+len(en)], en)
+// ReplaceTrailer replaces the data following an MSQ block's strings area.
+func (m *BlockModifier) ReplaceTrailer(trailer []byte) error {
+	return m.apply(func(tx *Tx) { tx.ReplaceTrailer(trailer) })
+}
 
-	return nil
+// FreeSpace calculates the number of bytes in the block's secure section that
+// are available to replacements that are larger than the originals.
+func (m *BlockModifier) FreeSpace() (int, error) {
+	return BlockFreeSpace(m.body, m.dim)
 }
 
 // Body returns a BlockModifier's modified body.
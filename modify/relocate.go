@@ -0,0 +1,549 @@
+package modify
+
+import (
+	"sort"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+	"github.com/badvassal/wllib/msq"
+)
+
+// AreaGap is the pseudo central directory pointer index of an area that is not
+// referenced by the central directory (e.g., padding between the map info
+// section and the first action table, or bytes following the last element of
+// a table).  The purpose of a gap's contents is unknown, so only its trailing
+// zero bytes are considered free.
+const AreaGap = -1
+
+// Area is a contiguous region of an MSQ block's secure section located after
+// the map info section.
+type Area struct {
+	CDPtrIdx int // Pointer that references the area (CDPtrIdx*) or AreaGap.
+	Offset   int // Relative to the start of the secure section.
+	Size     int // Number of bytes until the next area.
+	Used     int // Number of bytes actually occupied by the area's contents.
+	// For a gap, this includes everything up to its last non-zero byte.
+}
+
+// Layout describes how the areas referenced by a block's central directory are
+// arranged in its secure section.
+type Layout struct {
+	Start int    // Offset of the first byte after the map info section.
+	Areas []Area // Sorted by offset; covers everything from Start to the end.
+}
+
+// Slack is the number of unused bytes at the end of an area.  These bytes can
+// be reclaimed without affecting the way the block decodes.
+func (a Area) Slack() int {
+	return a.Size - a.Used
+}
+
+// areaTolerant indicates whether an area can contain trailing bytes that are
+// not part of its contents.  Most areas extend until the next area begins, so
+// any extra bytes would be decoded as part of them.  Tables whose elements
+// have a well-defined length ignore anything after their final element.
+func areaTolerant(cdPtrIdx int) bool {
+	switch cdPtrIdx {
+	case AreaGap,
+		decode.CDPtrIdxNPCTable,
+		decode.ActionTablePtrPrio(action.IDLoot),
+		decode.ActionTablePtrPrio(action.IDTransition):
+
+		return true
+
+	default:
+		return false
+	}
+}
+
+// tableUsedLen calculates the number of bytes occupied by a table.  baseOff is
+// the offset of the start of the table relative to the start of the secure
+// section.  elemLen calculates the length of a single encoded element.
+func tableUsedLen(data []byte, baseOff int,
+	elemLen func(elem []byte) (int, error)) (int, error) {
+
+	ptrs, _, err := gen.ReadPointers(data, baseOff)
+	if err != nil {
+		return 0, err
+	}
+
+	t, err := gen.ParseTable(data, baseOff)
+	if err != nil {
+		return 0, err
+	}
+
+	last := -1
+	for i, p := range ptrs {
+		if p != 0 {
+			last = i
+		}
+	}
+	if last < 0 {
+		return len(ptrs) * 2, nil
+	}
+
+	n, err := elemLen(t.Elems[last])
+	if err != nil {
+		return 0, err
+	}
+
+	return ptrs[last] - baseOff + n, nil
+}
+
+// areaMovable indicates whether an area's contents can be placed at a
+// different offset.  Special actions cannot be moved (see
+// decode.SpecialActions).  Neither can a gap containing non-zero bytes:
+// nothing is known about what refers to them.
+func areaMovable(a Area) bool {
+	switch a.CDPtrIdx {
+	case decode.CDPtrIdxSpecialActions:
+		return false
+	case AreaGap:
+		return a.Used == 0
+	default:
+		return true
+	}
+}
+
+// nonZeroLen calculates the length of a byte sequence excluding its trailing
+// zero bytes.
+func nonZeroLen(data []byte) int {
+	n := len(data)
+	for n > 0 && data[n-1] == 0 {
+		n--
+	}
+
+	return n
+}
+
+// areaUsedLen calculates the number of bytes occupied by an area's contents.
+// data is the area as carved from the secure section.  baseOff is the offset
+// of the start of the area relative to the start of the secure section.
+func areaUsedLen(cdPtrIdx int, data []byte, baseOff int) int {
+	var n int
+	var err error
+
+	switch cdPtrIdx {
+	case decode.CDPtrIdxNPCTable:
+		_, n, err = decode.DecodeNPCTable(data, baseOff)
+
+	case decode.ActionTablePtrPrio(action.IDLoot):
+		n, err = tableUsedLen(data, baseOff, func(elem []byte) (int, error) {
+			_, n, err := action.DecodeLoot(elem)
+			return n, err
+		})
+
+	case decode.ActionTablePtrPrio(action.IDTransition):
+		n, err = tableUsedLen(data, baseOff, func(elem []byte) (int, error) {
+			_, n, err := action.DecodeTransition(elem)
+			return n, err
+		})
+
+	default:
+		return len(data)
+	}
+
+	if err != nil || n > len(data) {
+		// Can't tell where the contents end.  Assume there is no slack.
+		return len(data)
+	}
+
+	return n
+}
+
+// ScanLayout determines the layout of an MSQ block's secure section.  dim is
+// the dimensions of the block's map.
+func ScanLayout(body msq.Body, dim gen.Point) (*Layout, error) {
+	onErr := wlerr.MakeWrapper("failed to scan block layout")
+
+	cb, err := decode.CarveBlock(body, dim)
+	if err != nil {
+		return nil, onErr(err, "")
+	}
+
+	cd, err := decode.DecodeCentralDir(cb.CentralDir)
+	if err != nil {
+		return nil, onErr(err, "")
+	}
+
+	start := cb.Offsets.MapInfo + len(cb.MapInfo)
+	end := len(body.SecSection)
+
+	ptrs := cd.Pointers()
+	su := gen.SortedUniqueInts(ptrs)
+
+	var areas []Area
+	for i := 0; i < decode.CDPtrIdxStrings; i++ {
+		if cd.AreaIsEmpty(i) {
+			continue
+		}
+
+		p := ptrs[i]
+		next := gen.NextInt(p, su, end)
+		if p < start || next > end {
+			return nil, onErr(nil,
+				"area out of bounds: cdptridx=%d off=%d end=%d want>=%d&&<=%d",
+				i, p, next, start, end)
+		}
+
+		a := Area{
+			CDPtrIdx: i,
+			Offset:   p,
+			Size:     next - p,
+			Used:     areaUsedLen(i, body.SecSection[p:next], p),
+		}
+		if areaTolerant(i) {
+			// Nothing is known about the bytes following the table's final
+			// element.  Describe them as a gap so that only zero bytes count
+			// as slack.
+			a.Size = a.Used
+		}
+
+		areas = append(areas, a)
+	}
+
+	sort.Slice(areas, func(i int, j int) bool {
+		return areas[i].Offset < areas[j].Offset
+	})
+
+	l := &Layout{
+		Start: start,
+	}
+
+	addGap := func(off int, size int) {
+		if size > 0 {
+			l.Areas = append(l.Areas, Area{
+				CDPtrIdx: AreaGap,
+				Offset:   off,
+				Size:     size,
+				Used:     nonZeroLen(body.SecSection[off : off+size]),
+			})
+		}
+	}
+
+	cur := start
+	for _, a := range areas {
+		if a.Offset < cur {
+			return nil, onErr(nil,
+				"overlapping areas: cdptridx=%d off=%d prev-end=%d",
+				a.CDPtrIdx, a.Offset, cur)
+		}
+
+		addGap(cur, a.Offset-cur)
+		l.Areas = append(l.Areas, a)
+		cur = a.Offset + a.Size
+	}
+	addGap(cur, end-cur)
+
+	return l, nil
+}
+
+// FreeSpace calculates the total number of bytes that can be reclaimed from a
+// layout's areas.
+func (l *Layout) FreeSpace() int {
+	total := 0
+	for _, a := range l.Areas {
+		if areaTolerant(a.CDPtrIdx) {
+			total += a.Slack()
+		}
+	}
+
+	return total
+}
+
+// areaIdx retrieves the index of the area referenced by the specified central
+// directory pointer.  It returns -1 if the layout does not contain the area.
+func (l *Layout) areaIdx(cdPtrIdx int) int {
+	for i, a := range l.Areas {
+		if a.CDPtrIdx == cdPtrIdx {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// resize calculates the area sizes that result from changing the size of a
+// single area to newSize while keeping the total size of the layout constant.
+// Bytes are taken from (or given to) areas that can tolerate slack, starting
+// with those closest to and following the resized area.  An area that cannot
+// be moved is never shifted: slack on its far side is not used.
+func (l *Layout) resize(idx int, newSize int) ([]int, error) {
+	sizes := make([]int, len(l.Areas))
+	for i, a := range l.Areas {
+		sizes[i] = a.Size
+	}
+	sizes[idx] = newSize
+
+	// Visit the other areas in order of preference.  Resizing a following
+	// area shifts its start; resizing a preceding area only shifts the areas
+	// after it.
+	var order []int
+	for i := idx + 1; i < len(l.Areas) && areaMovable(l.Areas[i]); i++ {
+		order = append(order, i)
+	}
+	for i := idx - 1; i >= 0; i-- {
+		order = append(order, i)
+		if !areaMovable(l.Areas[i]) {
+			break
+		}
+	}
+
+	delta := newSize - l.Areas[idx].Size
+
+	if delta < 0 {
+		// The area shrank.  Give the freed bytes to the nearest area that can
+		// hold them.
+		for _, i := range order {
+			if areaTolerant(l.Areas[i].CDPtrIdx) {
+				sizes[i] -= delta
+				return sizes, nil
+			}
+		}
+
+		return nil, wlerr.Errorf(
+			"no area can absorb %d freed bytes", -delta)
+	}
+
+	for _, i := range order {
+		if delta == 0 {
+			break
+		}
+
+		a := l.Areas[i]
+		if !areaTolerant(a.CDPtrIdx) {
+			continue
+		}
+
+		take := a.Slack()
+		if take > delta {
+			take = delta
+		}
+		sizes[i] -= take
+		delta -= take
+	}
+
+	if delta > 0 {
+		return nil, wlerr.Errorf(
+			"insufficient free space: need=%d have=%d",
+			newSize-l.Areas[idx].Size, l.FreeSpace()-l.Areas[idx].Slack())
+	}
+
+	return sizes, nil
+}
+
+// rebaseTable adjusts the pointers at the start of a table after the table
+// has been moved.  Null pointers are left untouched.
+func rebaseTable(data []byte, oldOff int, newOff int) ([]byte, error) {
+	ptrs, _, err := gen.ReadPointers(data, oldOff)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	for i, p := range ptrs {
+		if p != 0 {
+			copy(out[i*2:i*2+2], gen.WriteUint16(uint16(p+newOff-oldOff)))
+		}
+	}
+
+	return out, nil
+}
+
+// moveArea prepares the contents of an area for placement at a new offset.
+// data is the area's contents.
+func moveArea(cdPtrIdx int, data []byte, oldOff int, newOff int) ([]byte, error) {
+	if oldOff == newOff || len(data) == 0 {
+		return data, nil
+	}
+
+	if cdPtrIdx == decode.CDPtrIdxNPCTable ||
+		decode.ActionTableIdx(cdPtrIdx) >= 0 {
+
+		return rebaseTable(data, oldOff, newOff)
+	}
+
+	if cdPtrIdx == decode.CDPtrIdxSpecialActions {
+		return nil, wlerr.Errorf("special actions cannot be moved")
+	}
+
+	if cdPtrIdx == AreaGap {
+		return nil, wlerr.Errorf("gap containing unknown data cannot be moved")
+	}
+
+	// Other areas don't contain any pointers; just copy them.
+	return data, nil
+}
+
+// ReplaceArea replaces the contents of an area in a block's secure section.
+// If the replacement does not have the same size as the original, neighbouring
+// areas are moved into (or away from) slack so that the size of the secure
+// section does not change.  Only zero bytes are treated as slack, and a gap
+// containing non-zero bytes is never moved.  sec is the secure section to
+// modify.  cdOff is the offset of the block's central directory.  cdPtrIdx is
+// the index of the central directory pointer that references the area to
+// replace.  encode produces the replacement; its argument is the final offset
+// of the area.  The function returns the modified secure section; the
+// original is not altered.  On success, l is updated to describe the modified
+// secure section.
+func (l *Layout) ReplaceArea(sec []byte, cdOff int, cdPtrIdx int,
+	encode func(baseOff int) ([]byte, error)) ([]byte, error) {
+
+	onErr := wlerr.MakeWrapper("failed to replace area: cdptridx=%d", cdPtrIdx)
+
+	cd, err := decode.DecodeCentralDir(sec[cdOff:])
+	if err != nil {
+		return nil, onErr(err, "")
+	}
+	ptrs := cd.Pointers()
+
+	areas := make([]Area, len(l.Areas))
+	copy(areas, l.Areas)
+
+	idx := l.areaIdx(cdPtrIdx)
+	if idx < 0 {
+		// The area is currently empty.  Insert it in front of the area its
+		// pointer aliases.
+		p := ptrs[cdPtrIdx]
+
+		idx = len(areas)
+		if p == 0 {
+			// The pointer is null.  Put the area where it belongs in
+			// priority order: in front of the first area with a higher
+			// priority pointer, or at the end of the secure section.
+			p = len(sec)
+			for i, a := range areas {
+				if a.CDPtrIdx > cdPtrIdx {
+					idx = i
+					p = a.Offset
+					break
+				}
+			}
+		} else {
+			for i, a := range areas {
+				if a.Offset == p && a.CDPtrIdx != AreaGap {
+					idx = i
+					break
+				}
+			}
+		}
+
+		ins := Area{CDPtrIdx: cdPtrIdx, Offset: p}
+		areas = append(areas[:idx], append([]Area{ins}, areas[idx:]...)...)
+	}
+	tmp := &Layout{Start: l.Start, Areas: areas}
+
+	data, err := encode(areas[idx].Offset)
+	if err != nil {
+		return nil, onErr(err, "")
+	}
+
+	newSize := len(data)
+	if areaTolerant(cdPtrIdx) && newSize < areas[idx].Size {
+		// No need to move anything; just leave some slack at the end.
+		newSize = areas[idx].Size
+	}
+
+	sizes, err := tmp.resize(idx, newSize)
+	if err != nil {
+		return nil, onErr(err, "")
+	}
+
+	out := make([]byte, len(sec))
+	copy(out, sec)
+
+	newOffs := make([]int, len(areas))
+	off := l.Start
+	for i := range areas {
+		newOffs[i] = off
+		off += sizes[i]
+	}
+	gen.Assert(off == len(sec))
+
+	for i, a := range areas {
+		newOff := newOffs[i]
+		dst := out[newOff : newOff+sizes[i]]
+
+		if i == idx {
+			if newOff != a.Offset {
+				data, err = encode(newOff)
+				if err != nil {
+					return nil, onErr(err, "")
+				}
+			}
+
+			// Don't leave any of the old contents behind as slack.
+			zeroBytes(dst)
+			copy(dst, data)
+			continue
+		}
+
+		if newOff == a.Offset && sizes[i] == a.Size {
+			// Area hasn't moved.
+			continue
+		}
+
+		moved, err := moveArea(a.CDPtrIdx,
+			sec[a.Offset:a.Offset+a.Used], a.Offset, newOff)
+		if err != nil {
+			return nil, onErr(err, "failed to move area %d", a.CDPtrIdx)
+		}
+
+		// Whatever follows the area's contents is slack, i.e., zero bytes.
+		zeroBytes(dst)
+		copy(dst, moved)
+	}
+
+	// Update the central directory.  Pointers that alias an area follow it
+	// to its new location.
+	// A newly inserted area has a size of 0; pointers that alias its old
+	// offset belong to the area that follows it.
+	remap := map[int]int{}
+	for i, a := range areas {
+		if a.CDPtrIdx != AreaGap && a.Size > 0 {
+			remap[a.Offset] = newOffs[i]
+		}
+	}
+	for i, p := range ptrs {
+		if i == cdPtrIdx {
+			cd.SetPointer(i, newOffs[idx])
+		} else if np, ok := remap[p]; ok && p != 0 {
+			cd.SetPointer(i, np)
+		}
+	}
+	copy(out[cdOff:cdOff+decode.CentralDirLen], decode.EncodeCentralDir(*cd))
+
+	// Describe the new arrangement so that further replacements can reuse
+	// this layout.
+	for i := range areas {
+		areas[i].Offset = newOffs[i]
+		areas[i].Size = sizes[i]
+		if i == idx {
+			areas[i].Used = len(data)
+		}
+	}
+	l.Areas = areas
+
+	return out, nil
+}
+
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// BlockFreeSpace calculates the number of bytes in an MSQ block's secure
+// section that could be reclaimed by a size-changing replacement.
+func BlockFreeSpace(body msq.Body, dim gen.Point) (int, error) {
+	l, err := ScanLayout(body, dim)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.FreeSpace(), nil
+}
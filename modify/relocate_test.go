@@ -0,0 +1,158 @@
+package modify
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/internal/blocktest"
+	"github.com/badvassal/wllib/serialize"
+)
+
+func makeLoots(numItems int) []*action.Loot {
+	loot := &action.Loot{}
+	for i := 0; i < numItems; i++ {
+		loot.Items = append(loot.Items, action.LootItem{ID: i + 1, Amount: 1})
+	}
+
+	return []*action.Loot{loot}
+}
+
+func testModifier(t *testing.T) *BlockModifier {
+	b := blocktest.Block()
+	b.ActionTables = action.Tables{
+		Loots: makeLoots(10),
+		Transitions: []*action.Transition{
+			&action.Transition{Location: 1, ToClass: 0xff},
+		},
+	}
+
+	body, err := serialize.EncodeBlock(b, 0)
+	if err != nil {
+		t.Fatalf("failed to encode block: %v", err)
+	}
+
+	return NewBlockModifier(*body, b.Dim)
+}
+
+func TestRelocateIntoSlack(t *testing.T) {
+	m := testModifier(t)
+	secLen := len(m.Body().SecSection)
+
+	free, err := m.FreeSpace()
+	if err != nil {
+		t.Fatalf("failed to calculate free space: %v", err)
+	}
+	if free != 0 {
+		t.Fatalf("unexpected free space: have=%d want=0", free)
+	}
+
+	// Shrinking the loot table by 8 items leaves 16 bytes of slack.
+	if err := m.ReplaceLoots(makeLoots(2)); err != nil {
+		t.Fatalf("failed to shrink loot table: %v", err)
+	}
+
+	free, err = m.FreeSpace()
+	if err != nil {
+		t.Fatalf("failed to calculate free space: %v", err)
+	}
+	if free != 16 {
+		t.Fatalf("wrong free space: have=%d want=16", free)
+	}
+
+	// Grow the transition table into the slack left by the loot table.
+	ts := []*action.Transition{
+		&action.Transition{Location: 1, ToClass: 0xff},
+		&action.Transition{Location: 2, LocX: 7, ToClass: 0xff},
+	}
+	if err := m.ReplaceActionTransitions(ts); err != nil {
+		t.Fatalf("failed to grow transition table: %v", err)
+	}
+
+	if len(m.Body().SecSection) != secLen {
+		t.Fatalf("secure section changed size: have=%d want=%d",
+			len(m.Body().SecSection), secLen)
+	}
+
+	db, err := decode.DecodeBlock(m.Body(), m.dim)
+	if err != nil {
+		t.Fatalf("failed to decode modified block: %v", err)
+	}
+	if len(db.ActionTables.Loots[0].Items) != 2 {
+		t.Fatalf("wrong loot item count: have=%d want=2",
+			len(db.ActionTables.Loots[0].Items))
+	}
+	if len(db.ActionTables.Transitions) != 2 ||
+		db.ActionTables.Transitions[1].LocX != 7 {
+
+		t.Fatalf("transition table not replaced: %+v",
+			db.ActionTables.Transitions)
+	}
+	if db.MonsterData.Monsters[0].HitPoints != 5 {
+		t.Fatalf("monster data corrupted: %+v", db.MonsterData)
+	}
+
+	// Growing beyond the available slack must fail.
+	if err := m.ReplaceLoots(makeLoots(20)); err == nil {
+		t.Fatalf("oversized loot table accepted")
+	}
+}
+
+func TestRelocateKeepsUnknownBytes(t *testing.T) {
+	m := testModifier(t)
+
+	// Shrinking the loot table leaves 16 zero bytes after it.  Put some data
+	// of unknown purpose at the start of them.
+	if err := m.ReplaceLoots(makeLoots(2)); err != nil {
+		t.Fatalf("failed to shrink loot table: %v", err)
+	}
+
+	l, err := ScanLayout(m.Body(), m.dim)
+	if err != nil {
+		t.Fatalf("failed to scan layout: %v", err)
+	}
+	loot := l.Areas[l.areaIdx(decode.ActionTablePtrPrio(action.IDLoot))]
+	off := loot.Offset + loot.Size
+
+	unknown := []byte{0xde, 0xad, 0xbe, 0xef}
+	copy(m.body.SecSection[off:], unknown)
+
+	free, err := m.FreeSpace()
+	if err != nil {
+		t.Fatalf("failed to calculate free space: %v", err)
+	}
+	if free != 12 {
+		t.Fatalf("wrong free space: have=%d want=12", free)
+	}
+
+	// Growing the loot table would require moving the unknown data.
+	if err := m.ReplaceLoots(makeLoots(4)); err == nil {
+		t.Fatalf("loot table grew over unknown data")
+	}
+
+	// Growing the following table only consumes zero bytes.
+	ts := []*action.Transition{
+		&action.Transition{Location: 1, ToClass: 0xff},
+		&action.Transition{Location: 2, LocX: 7, ToClass: 0xff},
+	}
+	if err := m.ReplaceActionTransitions(ts); err != nil {
+		t.Fatalf("failed to grow transition table: %v", err)
+	}
+
+	if !bytes.Equal(m.Body().SecSection[off:off+len(unknown)], unknown) {
+		t.Fatalf("unknown data overwritten: have=% x want=% x",
+			m.Body().SecSection[off:off+len(unknown)], unknown)
+	}
+
+	db, err := decode.DecodeBlock(m.Body(), m.dim)
+	if err != nil {
+		t.Fatalf("failed to decode modified block: %v", err)
+	}
+	if len(db.ActionTables.Transitions) != 2 ||
+		db.ActionTables.Transitions[1].LocX != 7 {
+
+		t.Fatalf("transition table not replaced: %+v",
+			db.ActionTables.Transitions)
+	}
+}
@@ -0,0 +1,460 @@
+package modify
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+	"github.com/badvassal/wllib/msq"
+	"github.com/badvassal/wllib/serialize"
+)
+
+// ByteRange is a contiguous sequence of bytes in an MSQ block body.  Offsets
+// are relative to the start of the body (secure section followed by plain
+// section).
+type ByteRange struct {
+	Off int
+	Len int
+}
+
+// txState is the working copy of a block that a transaction's replacements
+// are applied to.
+type txState struct {
+	body   msq.Body
+	layout *Layout
+	dim    gen.Point
+	cdOff  int
+}
+
+// OpError describes a queued replacement that could not be applied.
+type OpError struct {
+	Desc string // Describes the replaced section (e.g., "loot table").
+	Err  error
+}
+
+// TxError is returned by Tx.Commit when one or more queued replacements fail.
+type TxError []OpError
+
+func (te TxError) Error() string {
+	var parts []string
+	for _, oe := range te {
+		parts = append(parts, fmt.Sprintf("%s: %v", oe.Desc, oe.Err))
+	}
+
+	return fmt.Sprintf(
+		"failed to commit block transaction: %d replacement(s) failed: %s",
+		len(te), strings.Join(parts, "; "))
+}
+
+// txOp is a single queued replacement.
+type txOp struct {
+	desc  string
+	apply func(st *txState) error
+}
+
+// Tx is a batch of replacements to be applied to a block as a single unit.
+// Replacements are queued with the Replace* methods and applied by Commit.
+// The block is decoded and scanned only once per commit, no matter how many
+// replacements are queued.
+type Tx struct {
+	m   *BlockModifier
+	ops []txOp
+}
+
+// Begin starts a transaction on the modifier's block.
+func (m *BlockModifier) Begin() *Tx {
+	return &Tx{
+		m: m,
+	}
+}
+
+func (tx *Tx) queue(desc string, apply func(st *txState) error) {
+	tx.ops = append(tx.ops, txOp{
+		desc:  desc,
+		apply: apply,
+	})
+}
+
+// replaceArea queues the replacement of an area in the block's secure
+// section.  See txState.replaceArea.
+func (tx *Tx) replaceArea(desc string, cdPtrIdx int,
+	encode func(baseOff int) ([]byte, error)) {
+
+	tx.queue(desc, func(st *txState) error {
+		return st.replaceArea(cdPtrIdx, encode)
+	})
+}
+
+// replaceArea replaces an area in the working copy's secure section.  See
+// Layout.ReplaceArea.
+func (st *txState) replaceArea(cdPtrIdx int,
+	encode func(baseOff int) ([]byte, error)) error {
+
+	if st.layout.areaIdx(cdPtrIdx) < 0 {
+		// Replacing an empty area with another empty one is a no-op.
+		data, err := encode(st.layout.Start)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return nil
+		}
+	}
+
+	sec, err := st.layout.ReplaceArea(st.body.SecSection, st.cdOff,
+		cdPtrIdx, encode)
+	if err != nil {
+		return err
+	}
+
+	st.body.SecSection = sec
+	return nil
+}
+
+// ReplaceMapInfo queues the replacement of the block's map info section.  The
+// replacement must be the same size as the original.
+func (tx *Tx) ReplaceMapInfo(mi decode.MapInfo) {
+	tx.queue("map info", func(st *txState) error {
+		smi := decode.EncodeMapInfo(mi)
+		if len(smi) != decode.MapInfoLen {
+			return fmt.Errorf("map infos differ in size: old=%d new=%d",
+				decode.MapInfoLen, len(smi))
+		}
+
+		off := st.cdOff + decode.CentralDirLen
+		copy(st.body.SecSection[off:off+len(smi)], smi)
+		return nil
+	})
+}
+
+// ReplaceMonsterData queues the replacement of the block's monster data
+// section.  The replacement must be the same size as the original.
+func (tx *Tx) ReplaceMonsterData(md decode.MonsterData) {
+	tx.queue("monster data", func(st *txState) error {
+		idx := st.layout.areaIdx(decode.CDPtrIdxMonsterData)
+		if idx < 0 {
+			return fmt.Errorf("block has no monster data")
+		}
+		a := st.layout.Areas[idx]
+
+		smd := decode.EncodeMonsterData(md)
+		if len(smd) != a.Size {
+			return fmt.Errorf("monster datas differ in size: old=%d new=%d",
+				a.Size, len(smd))
+		}
+
+		copy(st.body.SecSection[a.Offset:a.Offset+a.Size], smd)
+		return nil
+	})
+}
+
+// ReplaceLoots queues the replacement of the block's loot table.  See
+// BlockModifier.ReplaceLoots.
+func (tx *Tx) ReplaceLoots(loots []*action.Loot) {
+	tx.replaceArea("loot table", decode.ActionTablePtrPrio(action.IDLoot),
+		func(baseOff int) ([]byte, error) {
+			return serialize.SerializeActionLoots(loots, baseOff), nil
+		})
+}
+
+// ReplaceShops queues the replacement of the block's shop table.  See
+// BlockModifier.ReplaceShops.
+func (tx *Tx) ReplaceShops(shops []*action.Shop) {
+	tx.replaceArea("shop table", decode.ActionTablePtrPrio(action.IDShop),
+		func(baseOff int) ([]byte, error) {
+			tables := action.Tables{Shops: shops}
+			return serialize.SerializeActionTable(tables, action.IDShop,
+				baseOff), nil
+		})
+}
+
+// ReplaceActionTransitions queues the replacement of the block's transitions
+// action table.  See BlockModifier.ReplaceActionTransitions.
+func (tx *Tx) ReplaceActionTransitions(transitions []*action.Transition) {
+	tx.replaceArea("action transitions",
+		decode.ActionTablePtrPrio(action.IDTransition),
+		func(baseOff int) ([]byte, error) {
+			return serialize.SerializeActionTransitions(
+				transitions, baseOff), nil
+		})
+}
+
+// ReplaceNPCTable queues the replacement of the block's NPC table.  See
+// BlockModifier.ReplaceNPCTable.  An NPC table cannot be removed: replacing a
+// block's NPC table with an empty one fails.
+func (tx *Tx) ReplaceNPCTable(npcTable decode.NPCTable) {
+	if len(npcTable.NPCs) == 0 {
+		tx.queue("NPC table", func(st *txState) error {
+			if st.layout.areaIdx(decode.CDPtrIdxNPCTable) < 0 {
+				// Nothing to replace.
+				return nil
+			}
+			return fmt.Errorf("removing an NPC table is not supported")
+		})
+		return
+	}
+
+	tx.replaceArea("NPC table", decode.CDPtrIdxNPCTable,
+		func(baseOff int) ([]byte, error) {
+			return decode.EncodeNPCTable(npcTable, baseOff)
+		})
+}
+
+// ReplaceMapData queues the replacement of the block's map data section.  The
+// replacement must have the same dimensions as the block's map.
+func (tx *Tx) ReplaceMapData(md decode.MapData) {
+	tx.queue("map data", func(st *txState) error {
+		badDim := len(md.ActionClasses) != st.dim.Y ||
+			len(md.ActionSelectors) != st.dim.Y
+		for y := 0; !badDim && y < st.dim.Y; y++ {
+			badDim = len(md.ActionClasses[y]) != st.dim.X ||
+				len(md.ActionSelectors[y]) != st.dim.X
+		}
+		if badDim {
+			return fmt.Errorf("map data has wrong dimensions: want=%+v",
+				st.dim)
+		}
+
+		smd := decode.EncodeMapData(md)
+		copy(st.body.SecSection[:len(smd)], smd)
+		return nil
+	})
+}
+
+// ReplaceCentralDir queues the replacement of the block's central directory.
+// The pointers are written as-is; no areas are moved.  The replacement fails
+// if the resulting block cannot be carved.  Subsequent replacements in the
+// same transaction use the new pointers.
+func (tx *Tx) ReplaceCentralDir(cd decode.CentralDir) {
+	tx.queue("central directory", func(st *txState) error {
+		body := *st.body.Clone()
+
+		scd := decode.EncodeCentralDir(cd)
+		copy(body.SecSection[st.cdOff:st.cdOff+len(scd)], scd)
+
+		l, err := ScanLayout(body, st.dim)
+		if err != nil {
+			return err
+		}
+
+		st.body = body
+		st.layout = l
+		return nil
+	})
+}
+
+// ReplaceMonsterNames queues the replacement of the block's monster names
+// section.  If the replacement is larger than the original, it is allowed to
+// grow into the block's free space.
+func (tx *Tx) ReplaceMonsterNames(mn decode.MonsterNames) {
+	tx.replaceArea("monster names", decode.CDPtrIdxMonsterNames,
+		func(baseOff int) ([]byte, error) {
+			return decode.EncodeMonsterNames(mn), nil
+		})
+}
+
+// ReplaceSpecialActions queues the replacement of the block's special actions
+// section.  The area is never moved (see decode.SpecialActions): the
+// replacement fails if it would have to be placed at a different offset than
+// the original, or if the block has no special actions area.
+func (tx *Tx) ReplaceSpecialActions(sa decode.SpecialActions) {
+	tx.queue("special actions", func(st *txState) error {
+		data := decode.EncodeSpecialActions(sa)
+
+		idx := st.layout.areaIdx(decode.CDPtrIdxSpecialActions)
+		if idx < 0 {
+			if len(data) == 0 {
+				return nil
+			}
+			// There is no offset that the bytecode is known to be valid at.
+			return fmt.Errorf("block has no special actions area")
+		}
+		fixedOff := st.layout.Areas[idx].Offset
+
+		return st.replaceArea(decode.CDPtrIdxSpecialActions,
+			func(baseOff int) ([]byte, error) {
+				if baseOff != fixedOff {
+					return nil, fmt.Errorf(
+						"special actions cannot be moved: have=%d want=%d",
+						baseOff, fixedOff)
+				}
+				return data, nil
+			})
+	})
+}
+
+// ReplaceActionTable queues the replacement of a single action table.  idx is
+// the index of the table to replace (0-15); the table is taken from tables.
+func (tx *Tx) ReplaceActionTable(idx int, tables action.Tables) {
+	desc := fmt.Sprintf("action table %d", idx)
+
+	if idx < 0 || idx >= 16 {
+		tx.queue(desc, func(st *txState) error {
+			return fmt.Errorf("invalid action table index: have=%d want=0-15",
+				idx)
+		})
+		return
+	}
+
+	tx.replaceArea(desc, decode.ActionTablePtrPrio(idx),
+		func(baseOff int) ([]byte, error) {
+			return serialize.SerializeActionTable(tables, idx, baseOff), nil
+		})
+}
+
+// ReplaceActionTables queues the replacement of all 16 action tables.
+func (tx *Tx) ReplaceActionTables(tables action.Tables) {
+	for i := 0; i < 16; i++ {
+		tx.ReplaceActionTable(i, tables)
+	}
+}
+
+// Indices of the parts of a plain section.
+const (
+	plainStrings = iota
+	plainTrailer
+)
+
+// plainParts splits a plain section into its strings area and trailer.
+func plainParts(plain []byte) ([][]byte, error) {
+	sa, trailer, err := decode.SplitPlainSection(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{sa, trailer}, nil
+}
+
+// replacePlainPart queues the replacement of one part of the block's plain
+// section.  The parts that follow it are shifted as necessary.  The plain
+// section keeps its size: it is padded with zeros if it shrinks, and it may
+// only grow by consuming trailing zero bytes.
+func (tx *Tx) replacePlainPart(desc string, part int,
+	encode func(old []byte) ([]byte, error)) {
+
+	tx.queue(desc, func(st *txState) error {
+		parts, err := plainParts(st.body.PlainSection)
+		if err != nil {
+			return err
+		}
+
+		parts[part], err = encode(parts[part])
+		if err != nil {
+			return err
+		}
+
+		var plain []byte
+		for _, p := range parts {
+			plain = append(plain, p...)
+		}
+
+		size := len(st.body.PlainSection)
+		if len(plain) > size {
+			for _, b := range plain[size:] {
+				if b != 0 {
+					return fmt.Errorf(
+						"plain section overflow: have=%d want<=%d",
+						len(plain), size)
+				}
+			}
+			plain = plain[:size]
+		} else {
+			plain = append(plain, make([]byte, size-len(plain))...)
+		}
+
+		st.body.PlainSection = plain
+		return nil
+	})
+}
+
+// ReplaceStringsArea queues the replacement of the block's strings area.  If
+// the replacement is larger than the original, it is allowed to grow into
+// zero bytes at the end of the plain section.
+func (tx *Tx) ReplaceStringsArea(sa decode.StringsArea) {
+	tx.replacePlainPart("strings area", plainStrings,
+		func(old []byte) ([]byte, error) {
+			return decode.EncodeStringsArea(sa), nil
+		})
+}
+
+// ReplaceTrailer queues the replacement of the data following the block's
+// strings area.  See ReplaceStringsArea for size constraints.
+func (tx *Tx) ReplaceTrailer(trailer []byte) {
+	tx.replacePlainPart("trailer", plainTrailer,
+		func(old []byte) ([]byte, error) {
+			return trailer, nil
+		})
+}
+
+// changedRanges lists the byte ranges that differ between two block bodies of
+// the same size.
+func changedRanges(before msq.Body, after msq.Body) []ByteRange {
+	b := append(append([]byte(nil), before.SecSection...),
+		before.PlainSection...)
+	a := append(append([]byte(nil), after.SecSection...),
+		after.PlainSection...)
+
+	var rs []ByteRange
+	for i := 0; i < len(a); i++ {
+		if i < len(b) && a[i] == b[i] {
+			continue
+		}
+
+		start := i
+		for i < len(a) && (i >= len(b) || a[i] != b[i]) {
+			i++
+		}
+		rs = append(rs, ByteRange{Off: start, Len: i - start})
+	}
+
+	return rs
+}
+
+// Commit applies every queued replacement, in order, to the block.  Either
+// all replacements succeed or the block is left untouched.  It returns the
+// byte ranges of the block body that changed.  If any replacements fail, the
+// remaining ones are still attempted (against the working copy) so that the
+// returned TxError lists every failure.
+func (tx *Tx) Commit() ([]ByteRange, error) {
+	onErr := wlerr.MakeWrapper("failed to commit block transaction")
+
+	if len(tx.ops) == 0 {
+		return nil, nil
+	}
+
+	before := tx.m.body
+
+	l, err := ScanLayout(before, tx.m.dim)
+	if err != nil {
+		return nil, onErr(err, "")
+	}
+
+	st := &txState{
+		body:   *before.Clone(),
+		layout: l,
+		dim:    tx.m.dim,
+		cdOff:  decode.MapDataLen(tx.m.dim),
+	}
+
+	var terr TxError
+	for _, op := range tx.ops {
+		// A failed replacement leaves the working copy as it was.
+		if err := op.apply(st); err != nil {
+			terr = append(terr, OpError{
+				Desc: op.desc,
+				Err:  err,
+			})
+		}
+	}
+	if len(terr) > 0 {
+		return nil, terr
+	}
+
+	tx.m.body = st.body
+	tx.ops = nil
+
+	return changedRanges(before, st.body), nil
+}
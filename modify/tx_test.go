@@ -0,0 +1,185 @@
+package modify
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/serialize"
+)
+
+func TestTxAtomic(t *testing.T) {
+	m := testModifier(t)
+	origSec := append([]byte(nil), m.Body().SecSection...)
+
+	// The second replacement has the wrong size and the third refers to an
+	// invalid table, so none may be applied.  Both failures are reported.
+	tx := m.Begin()
+	tx.ReplaceLoots(makeLoots(2))
+	tx.ReplaceMonsterData(decode.MonsterData{})
+	tx.ReplaceActionTable(16, action.Tables{})
+	_, err := tx.Commit()
+	terr, ok := err.(TxError)
+	if !ok || len(terr) != 2 || terr[0].Desc != "monster data" {
+		t.Fatalf("wrong commit error: %v", err)
+	}
+
+	body := m.Body()
+	if !bytes.Equal(body.SecSection, origSec) {
+		t.Fatalf("failed transaction modified block")
+	}
+}
+
+func TestTxMultipleReplacements(t *testing.T) {
+	m := testModifier(t)
+	secLen := len(m.Body().SecSection)
+
+	// Shrink the loot table, then let the transitions grow into the freed
+	// space, all against a single scanned layout.
+	trans := []*action.Transition{
+		&action.Transition{Location: 1, ToClass: 0xff},
+		&action.Transition{Location: 2, ToClass: 0xff},
+	}
+
+	tx := m.Begin()
+	tx.ReplaceLoots(makeLoots(2))
+	tx.ReplaceActionTransitions(trans)
+	tx.ReplaceMonsterData(decode.MonsterData{
+		Monsters: []decode.MonsterDataElem{{HitPoints: 9}},
+	})
+
+	ranges, err := tx.Commit()
+	if err != nil {
+		t.Fatalf("failed to commit transaction: %v", err)
+	}
+	if len(ranges) == 0 {
+		t.Fatalf("no changed ranges reported")
+	}
+	if len(m.Body().SecSection) != secLen {
+		t.Fatalf("secure section changed size: have=%d want=%d",
+			len(m.Body().SecSection), secLen)
+	}
+
+	db, err := decode.DecodeBlock(m.Body(), m.dim)
+	if err != nil {
+		t.Fatalf("failed to decode modified block: %v", err)
+	}
+	if len(db.ActionTables.Loots[0].Items) != 2 ||
+		len(db.ActionTables.Transitions) != 2 ||
+		db.MonsterData.Monsters[0].HitPoints != 9 {
+
+		t.Fatalf("replacements not applied: loots=%+v trans=%d mon=%+v",
+			db.ActionTables.Loots[0], len(db.ActionTables.Transitions),
+			db.MonsterData.Monsters)
+	}
+}
+
+func TestTxEverySection(t *testing.T) {
+	m := testModifier(t)
+	origPlainLen := len(m.Body().PlainSection)
+
+	db, err := decode.DecodeBlock(m.Body(), m.dim)
+	if err != nil {
+		t.Fatalf("failed to decode block: %v", err)
+	}
+
+	// Shrink the loot table to make room for the larger sections.
+	db.ActionTables.Loots = makeLoots(2)
+	db.MapData.ActionSelectors[1][3] = 7
+	db.MonsterNames.Names = []decode.MonsterName{{Start: "rat"}}
+	db.ActionTables.Prints = []*action.Print{&action.Print{ToClass: 0xff, StringIDs: []int{2}}}
+	db.StringsArea.StringData = append(db.StringsArea.StringData, 0, 0)
+
+	tx := m.Begin()
+	tx.ReplaceLoots(db.ActionTables.Loots)
+	tx.ReplaceMapData(db.MapData)
+	tx.ReplaceActionTables(db.ActionTables)
+	tx.ReplaceMonsterNames(db.MonsterNames)
+	tx.ReplaceSpecialActions(db.SpecialActions)
+	tx.ReplaceStringsArea(db.StringsArea)
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit transaction: %v", err)
+	}
+
+	if len(m.Body().PlainSection) != origPlainLen {
+		t.Fatalf("plain section changed size: have=%d want=%d",
+			len(m.Body().PlainSection), origPlainLen)
+	}
+
+	nb, err := decode.DecodeBlock(m.Body(), m.dim)
+	if err != nil {
+		t.Fatalf("failed to decode modified block: %v", err)
+	}
+	if nb.MapData.ActionSelectors[1][3] != 7 ||
+		len(nb.MonsterNames.Names) != 1 ||
+		len(nb.ActionTables.Prints) != 1 ||
+		len(nb.ActionTables.Loots) != len(db.ActionTables.Loots) {
+
+		t.Fatalf("replacements not applied: %+v", nb)
+	}
+}
+
+func TestTxSpecialActionsImmovable(t *testing.T) {
+	m := testModifier(t)
+
+	db, err := decode.DecodeBlock(m.Body(), m.dim)
+	if err != nil {
+		t.Fatalf("failed to decode block: %v", err)
+	}
+	db.SpecialActions.Actions = []byte{0x01, 0x02, 0x03}
+	db.Offsets.SpecialActions = 0
+
+	body, err := serialize.EncodeBlock(*db, 0)
+	if err != nil {
+		t.Fatalf("failed to encode block: %v", err)
+	}
+	m = NewBlockModifier(*body, m.dim)
+
+	// The loot table's slack lies before the special actions, so monster
+	// names can only grow by moving the special actions.
+	tx := m.Begin()
+	tx.ReplaceLoots(makeLoots(2))
+	tx.ReplaceMonsterNames(decode.MonsterNames{
+		Names: []decode.MonsterName{{Start: "rat"}},
+	})
+	if _, err := tx.Commit(); err == nil {
+		t.Fatalf("special actions moved without error")
+	}
+
+	// Replacing them in place is fine.
+	err = m.ReplaceSpecialActions(decode.SpecialActions{
+		Actions: []byte{0x04, 0x05, 0x06},
+	})
+	if err != nil {
+		t.Fatalf("failed to replace special actions: %v", err)
+	}
+}
+
+func TestTxReplaceNPCTableEmpty(t *testing.T) {
+	m := testModifier(t)
+
+	// The block has no NPC table, so an empty replacement changes nothing.
+	if err := m.ReplaceNPCTable(decode.NPCTable{}); err != nil {
+		t.Fatalf("failed to replace absent NPC table: %v", err)
+	}
+
+	db, err := decode.DecodeBlock(m.Body(), m.dim)
+	if err != nil {
+		t.Fatalf("failed to decode block: %v", err)
+	}
+	db.NPCTable.NPCs = []decode.Character{{Name: "Bob", Strength: 12}}
+
+	body, err := serialize.EncodeBlock(*db, 0)
+	if err != nil {
+		t.Fatalf("failed to encode block: %v", err)
+	}
+	m = NewBlockModifier(*body, m.dim)
+
+	if err := m.ReplaceNPCTable(decode.NPCTable{}); err == nil {
+		t.Fatalf("NPC table removed without error")
+	}
+	if !bytes.Equal(m.Body().SecSection, body.SecSection) {
+		t.Fatalf("failed replacement modified block")
+	}
+}
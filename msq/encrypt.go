@@ -28,3 +28,10 @@ func Encrypt(plaintext []byte, xor0 byte, xor1 byte) []byte {
 
 	return cbytes
 }
+
+// Decrypt decrypts an MSQ block's secure section.  It is the inverse of
+// Encrypt.
+func Decrypt(ciphertext []byte, xor0 byte, xor1 byte) []byte {
+	// The cipher is a running XOR, so encryption is its own inverse.
+	return Encrypt(ciphertext, xor0, xor1)
+}
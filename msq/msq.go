@@ -1,5 +1,7 @@
 package msq
 
+import "fmt"
+
 const (
 	BlockPrefix = "msq"
 	HeaderLen   = 6
@@ -13,6 +15,11 @@ type Header struct {
 	Xor1    byte
 }
 
+// Checksum returns the secure section checksum recorded in the header.
+func (hdr *Header) Checksum() uint16 {
+	return uint16(hdr.Xor1)<<8 + uint16(hdr.Xor0)
+}
+
 // Body is a decoded and decrypted MSQ block body.  See
 // <https://wasteland.gamepedia.com/MSQ_Block>.
 type Body struct {
@@ -26,6 +33,17 @@ type Desc struct {
 	Offset int
 	Hdr    Header
 	Body   Body
+
+	// Candidates lists every plausible secure section length that was
+	// considered when the block was read.  More than one entry indicates that
+	// the boundary was ambiguous and the first was chosen.
+	Candidates []int
+}
+
+// Ambiguous indicates whether the block's secure section boundary had to be
+// chosen from more than one candidate.
+func (d *Desc) Ambiguous() bool {
+	return len(d.Candidates) > 1
 }
 
 // Clone performs a deep copy of a decoded MSQ block.
@@ -71,3 +89,37 @@ func EncodeMsqBlock(body Body, gameIdx int) []byte {
 
 	return out
 }
+
+// VerifyMsqBlock ensures that an encoded MSQ block parses back to the body it
+// was encoded from.  data is the output of EncodeMsqBlock.  Parsing can go
+// wrong if a running checksum matches the final checksum before the end of
+// the secure section, or if the encoded body contains the block prefix.  It
+// returns an error describing the first discrepancy.  blockIdx, isMap, and
+// validate must be the values the block will be parsed with (see
+// ParseGameValidate); validate may be nil.
+func VerifyMsqBlock(data []byte, body Body, blockIdx int, isMap bool,
+	validate BoundaryValidator) error {
+
+	desc, err := parseBlock(data, 0, blockIdx, isMap, validate)
+	if err != nil {
+		return fmt.Errorf("encoded block does not parse: %v", err)
+	}
+
+	have := len(desc.Body.SecSection) + len(desc.Body.PlainSection)
+	want := len(body.SecSection) + len(body.PlainSection)
+	if have != want {
+		return fmt.Errorf(
+			"encoded block contains block prefix: "+
+				"parsed-len=%d want=%d", have, want)
+	}
+
+	if len(desc.Body.SecSection) != len(body.SecSection) {
+		return fmt.Errorf(
+			"secure section boundary misdetected: "+
+				"have=%d want=%d candidates=%v",
+			len(desc.Body.SecSection), len(body.SecSection),
+			desc.Candidates)
+	}
+
+	return nil
+}
@@ -13,144 +13,127 @@ const (
 
 var stringPrefix = []byte{0x20, 0x65}
 
-type readState int
+// blockEnd finds the end of the MSQ block body starting at startOff.  A body
+// extends to the start of the next block or to the end of the file.
+func blockEnd(game []byte, startOff int) (int, error) {
+	if startOff >= len(game) {
+		return 0, fmt.Errorf(
+			"%s: truncated: start-off=%d", invRawMsg, startOff)
+	}
 
-const (
-	readSecSection readState = iota
-	readPlainSection
-)
+	idx := bytes.Index(game[startOff+1:], []byte(BlockPrefix))
+	if idx == -1 {
+		return len(game), nil
+	}
 
-type reader struct {
-	// Constant:
-	hdr       Header
-	src       []byte
-	startOff  int
-	isMap     bool
-	finalCsum uint16
-
-	// Variable
-	off          int
-	sec          byte
-	csum         uint16
-	state        readState
-	secSection   []byte
-	plainSection []byte
+	return startOff + 1 + idx, nil
 }
 
-// newReader constructs a reader for the specified GAMEx file contents.
-func newReader(hdr Header, game []byte, startOff int, isMap bool) *reader {
-	return &reader{
-		hdr:       hdr,
-		src:       game,
-		startOff:  startOff,
-		isMap:     isMap,
-		finalCsum: uint16(hdr.Xor1)<<8 + uint16(hdr.Xor0),
+// findCandidates calculates every secure section length that produces the
+// checksum in the block header.  dec is the decrypted block body.
+func findCandidates(dec []byte, hdr Header) []int {
+	finalCsum := hdr.Checksum()
 
-		off:   startOff,
-		sec:   hdr.Xor0 ^ hdr.Xor1,
-		state: readSecSection,
-	}
-}
+	var cands []int
 
-// bytesFollow indicates whether a given string of bytes follows the current
-// reader offset.
-func (r *reader) bytesFollow(b []byte) bool {
-	sub := r.src[r.off:]
-
-	if len(sub) < len(b) {
-		return false
+	csum := uint16(0)
+	for i := 0; i <= len(dec); i++ {
+		if csum == finalCsum {
+			cands = append(cands, i)
+		}
+		if i < len(dec) {
+			csum -= uint16(dec[i])
+		}
 	}
 
-	mine := sub[:len(b)]
-	return bytes.Compare(mine, b) == 0
+	return cands
 }
 
-// isDone indicates whether the MSQ block has been fully read.
-func (r *reader) isDone() bool {
-	if r.off == len(r.src) {
-		// End of file.
-		return true
+// plausibleBoundary indicates whether a checksum-match candidate could be the
+// true end of the secure section, based only on the block type.
+func plausibleBoundary(raw []byte, dec []byte, cand int, isMap bool) bool {
+	if isMap {
+		// The checksum alone leads to false positives.  The first plaintext
+		// section (strings section) of a map block must immediately follow.
+		return bytes.HasPrefix(raw[cand:], stringPrefix)
 	}
 
-	return r.bytesFollow([]byte(BlockPrefix))
+	// We don't know what follows the secure section in non-map blocks.  A
+	// zero byte doesn't change the checksum, so a run of zeros yields a run
+	// of candidates; only the last one in the run is plausible.
+	return cand == len(dec) || dec[cand] != 0
 }
 
-func (r *reader) readByte() (bool, error) {
-	if r.off >= len(r.src) {
-		return false, fmt.Errorf(
-			"%s: truncated: start-off=%d", invRawMsg, r.startOff)
-	}
-
-	rawbyte := r.src[r.off]
-
-	if r.state == readSecSection {
-		pbyte := rawbyte ^ r.sec
-		newCsum := r.csum - uint16(pbyte)
-
-		atEnd := func() bool {
-			// If our current checksum doesn't match the value indicated in the
-			// header then there is more data to decrypt.
-			if r.csum != r.finalCsum {
-				return false
-			}
-
-			if r.isMap {
-				// This is a bit of a hack.  The secure section ends when
-				// the checksum equals the value specified in the MSQ header.
-				// This leads to false positives.  To ignore false positives,
-				// ensure the first plaintext section (strings section)
-				// immediately follows.
-				return r.bytesFollow(stringPrefix)
-			} else {
-				// We don't know what follows the secure section in non-map
-				// blocks.  Just rely on the checksum.
-				return newCsum != r.finalCsum
-			}
-		}
+// BoundaryValidator checks whether a proposed secure section boundary is
+// correct.  blockIdx is the index of the block within its GAME file; body is
+// the block split at the proposed boundary.  It is used to resolve blocks
+// with more than one checksum-match candidate.
+type BoundaryValidator func(blockIdx int, body Body) bool
+
+// resolveBoundary determines the length of a block's secure section.  raw is
+// the block body, still encrypted.  It returns the chosen length and the list
+// of candidates it was chosen from.
+func resolveBoundary(raw []byte, hdr Header, blockIdx int, isMap bool,
+	validate BoundaryValidator) (int, []int) {
+
+	dec := Decrypt(raw, hdr.Xor0, hdr.Xor1)
 
-		if atEnd() {
-			log.Debugf("done reading secure map data")
-			r.state = readPlainSection
-		} else {
-			r.secSection = append(r.secSection, pbyte)
-			r.sec += 0x1f
-			r.csum = newCsum
+	split := func(n int) Body {
+		return Body{
+			SecSection:   dec[:n],
+			PlainSection: raw[n:],
 		}
 	}
 
-	if r.state == readPlainSection {
-		r.plainSection = append(r.plainSection, rawbyte)
+	var cands []int
+	for _, c := range findCandidates(dec, hdr) {
+		if !plausibleBoundary(raw, dec, c, isMap) {
+			continue
+		}
+		if validate != nil && !validate(blockIdx, split(c)) {
+			continue
+		}
+		cands = append(cands, c)
 	}
 
-	r.off++
+	switch len(cands) {
+	case 0:
+		// No boundary found; treat the whole body as secure section.
+		log.Debugf("no secure section boundary found: block=%d", blockIdx)
+		return len(raw), nil
 
-	if r.isDone() {
-		return true, nil
+	case 1:
+		return cands[0], cands
+
+	default:
+		log.Warnf("ambiguous secure section boundary; choosing first: "+
+			"block=%d candidates=%v", blockIdx, cands)
+		return cands[0], cands
 	}
+}
 
-	return false, nil
+// splitBody decrypts a block body whose secure section length is known.
+func splitBody(raw []byte, hdr Header, secLen int) *Body {
+	return &Body{
+		SecSection:   Decrypt(raw[:secLen], hdr.Xor0, hdr.Xor1),
+		PlainSection: append([]byte(nil), raw[secLen:]...),
+	}
 }
 
-func parseBody(game []byte, startOff int, hdr Header,
-	isMap bool) (*Body, error) {
+func parseBody(game []byte, startOff int, hdr Header, blockIdx int,
+	isMap bool, validate BoundaryValidator) (*Body, []int, error) {
 
-	r := newReader(hdr, game, startOff, isMap)
+	end, err := blockEnd(game, startOff)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	for {
-		done, err := r.readByte()
-		if err != nil {
-			return nil, err
-		}
+	raw := game[startOff:end]
+	secLen, cands := resolveBoundary(raw, hdr, blockIdx, isMap, validate)
 
-		if done {
-			break
-		}
-	}
+	log.Debugf("done reading secure data: len=%d", secLen)
 
-	return &Body{
-		SecSection:   r.secSection,
-		PlainSection: r.plainSection,
-	}, nil
+	return splitBody(raw, hdr, secLen), cands, nil
 }
 
 func parseHeader(game []byte, startOff int) (*Header, error) {
@@ -198,21 +181,25 @@ func parseHeader(game []byte, startOff int) (*Header, error) {
 
 // parseBlock decodes a single MSQ block.  isMap indicates whether the block to
 // be parsed is a map block (as opposed to e.g. character data).
-func parseBlock(game []byte, startOff int, isMap bool) (*Desc, error) {
+func parseBlock(game []byte, startOff int, blockIdx int, isMap bool,
+	validate BoundaryValidator) (*Desc, error) {
+
 	hdr, err := parseHeader(game, startOff)
 	if err != nil {
 		return nil, err
 	}
 
-	body, err := parseBody(game, startOff+HeaderLen, *hdr, isMap)
+	body, cands, err := parseBody(game, startOff+HeaderLen, *hdr, blockIdx,
+		isMap, validate)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Desc{
-		Offset: startOff,
-		Hdr:    *hdr,
-		Body:   *body,
+		Offset:     startOff,
+		Hdr:        *hdr,
+		Body:       *body,
+		Candidates: cands,
 	}, nil
 }
 
@@ -220,12 +207,23 @@ func parseBlock(game []byte, startOff int, isMap bool) (*Desc, error) {
 // blocks.  numMapBlocks is the number of blocks in the input which represent
 // maps (as opposed to e.g. character data).
 func ParseGame(game []byte, numMapBlocks int) ([]Desc, error) {
+	return ParseGameValidate(game, numMapBlocks, nil)
+}
+
+// ParseGameValidate is like ParseGame, but it uses the given validator to
+// choose among candidate secure section boundaries.  A nil validator accepts
+// every candidate.  If a block still has more than one candidate, the first
+// is chosen and a warning is logged; the candidates are recorded in the
+// block's descriptor.
+func ParseGameValidate(game []byte, numMapBlocks int,
+	validate BoundaryValidator) ([]Desc, error) {
+
 	var descs []Desc
 	for off := 0; off < len(game); {
 		isMap := len(descs) < numMapBlocks
 
 		log.Debugf("parsing block %d at offset %d\n", len(descs), off)
-		desc, err := parseBlock(game, off, isMap)
+		desc, err := parseBlock(game, off, len(descs), isMap, validate)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,83 @@
+package msq
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseGameRoundTrip(t *testing.T) {
+	bodies := []Body{
+		{
+			SecSection:   []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			PlainSection: []byte{0x20, 0x65, 9, 10},
+		},
+		{
+			SecSection:   []byte{11, 12, 13, 14},
+			PlainSection: []byte{15, 16},
+		},
+	}
+
+	var game []byte
+	for _, b := range bodies {
+		game = append(game, EncodeMsqBlock(b, 0)...)
+	}
+
+	descs, err := ParseGame(game, 1)
+	if err != nil {
+		t.Fatalf("failed to parse game: %v", err)
+	}
+	if len(descs) != len(bodies) {
+		t.Fatalf("wrong block count: have=%d want=%d",
+			len(descs), len(bodies))
+	}
+
+	for i, d := range descs {
+		if !bytes.Equal(d.Body.SecSection, bodies[i].SecSection) ||
+			!bytes.Equal(d.Body.PlainSection, bodies[i].PlainSection) {
+
+			t.Errorf("block %d mismatch: have=%+v want=%+v",
+				i, d.Body, bodies[i])
+		}
+		if d.Ambiguous() {
+			t.Errorf("block %d unexpectedly ambiguous: %v", i, d.Candidates)
+		}
+	}
+}
+
+func TestParseGameValidate(t *testing.T) {
+	// Follow the secure section with bytes that sum to zero (mod 2^16), so
+	// the checksum matches at two places.
+	sec := []byte{1, 2, 3}
+	var ext []byte
+	for i := 0; i < 257; i++ {
+		ext = append(ext, 0xff)
+	}
+	ext = append(ext, 0x01)
+
+	csum := CalcChecksum(sec)
+	hdr := Header{Xor0: byte(csum & 0xff), Xor1: byte(csum >> 8)}
+
+	game := EncodeMsqHeader(hdr)
+	game = append(game, Encrypt(append(sec, ext...), hdr.Xor0, hdr.Xor1)...)
+
+	descs, err := ParseGame(game, 0)
+	if err != nil {
+		t.Fatalf("failed to parse game: %v", err)
+	}
+	if !descs[0].Ambiguous() {
+		t.Fatalf("boundary not reported as ambiguous: %v",
+			descs[0].Candidates)
+	}
+
+	want := len(sec) + len(ext)
+	descs, err = ParseGameValidate(game, 0, func(idx int, b Body) bool {
+		return len(b.SecSection) == want
+	})
+	if err != nil {
+		t.Fatalf("failed to parse game: %v", err)
+	}
+	if len(descs[0].Body.SecSection) != want || descs[0].Ambiguous() {
+		t.Fatalf("validator not applied: sec=%d candidates=%v",
+			len(descs[0].Body.SecSection), descs[0].Candidates)
+	}
+}
@@ -0,0 +1,193 @@
+package msq
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// scanChunkLen is the number of bytes read at a time while searching for
+// block headers.
+const scanChunkLen = 0x10000
+
+// IndexEntry describes the location of a single MSQ block within a GAMEx
+// file.
+type IndexEntry struct {
+	Offset  int // Offset of the block header.
+	Hdr     Header
+	BodyLen int // Combined length of the block's two sections.
+}
+
+// boundary is the resolved secure section boundary of a scanned block.
+type boundary struct {
+	secLen int
+	cands  []int
+}
+
+// Scanner provides access to the MSQ blocks in a GAMEx file without loading
+// the whole file.  It builds an index of the blocks' headers when it is
+// created.  A block is only decrypted, and its secure section boundary only
+// resolved, when it is requested.
+type Scanner struct {
+	r            io.ReaderAt
+	numMapBlocks int
+	validate     BoundaryValidator
+	bounds       []*boundary // Resolved boundaries, nil until first access.
+	Index        []IndexEntry
+}
+
+// findPrefixes locates every occurrence of the MSQ block prefix in the first
+// size bytes of r.
+func findPrefixes(r io.ReaderAt, size int) ([]int, error) {
+	var offs []int
+
+	// Each chunk overlaps the previous one so that a prefix spanning a chunk
+	// boundary is found.
+	overlap := len(BlockPrefix) - 1
+	buf := make([]byte, scanChunkLen)
+
+	for base := 0; base < size; {
+		n := scanChunkLen
+		if base+n > size {
+			n = size - base
+		}
+
+		if _, err := r.ReadAt(buf[:n], int64(base)); err != nil &&
+			err != io.EOF {
+
+			return nil, fmt.Errorf("failed to read game: off=%d: %v",
+				base, err)
+		}
+
+		chunk := buf[:n]
+		for i := 0; ; {
+			idx := bytes.Index(chunk[i:], []byte(BlockPrefix))
+			if idx == -1 {
+				break
+			}
+
+			off := base + i + idx
+			if len(offs) == 0 || offs[len(offs)-1] != off {
+				offs = append(offs, off)
+			}
+			i += idx + 1
+		}
+
+		if base+n >= size {
+			break
+		}
+		base += n - overlap
+	}
+
+	return offs, nil
+}
+
+// NewScanner indexes the MSQ blocks in a GAMEx file.  r provides the file
+// contents and size is the file's length.  numMapBlocks and validate have the
+// same meaning as in ParseGameValidate.  Only the block prefixes and headers
+// are read; block bodies are left untouched until they are requested.
+func NewScanner(r io.ReaderAt, size int, numMapBlocks int,
+	validate BoundaryValidator) (*Scanner, error) {
+
+	prefixes, err := findPrefixes(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scanner{
+		r:            r,
+		numMapBlocks: numMapBlocks,
+		validate:     validate,
+	}
+
+	for off := 0; off < size; {
+		hdrBytes, err := s.read(off, HeaderLen)
+		if err != nil {
+			return nil, fmt.Errorf("%s: too few bytes: off=%d: %v",
+				invRawMsg, off, err)
+		}
+		hdr, err := parseHeader(hdrBytes, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		// The body extends to the next block prefix (at least one byte
+		// past the start of the body) or to the end of the file.
+		bodyOff := off + HeaderLen
+		if bodyOff >= size {
+			return nil, fmt.Errorf(
+				"%s: truncated: start-off=%d", invRawMsg, bodyOff)
+		}
+		end := size
+		for _, p := range prefixes {
+			if p > bodyOff {
+				end = p
+				break
+			}
+		}
+
+		s.Index = append(s.Index, IndexEntry{
+			Offset:  off,
+			Hdr:     *hdr,
+			BodyLen: end - bodyOff,
+		})
+
+		off = end
+	}
+
+	s.bounds = make([]*boundary, len(s.Index))
+
+	return s, nil
+}
+
+// read reads n bytes from the scanner's source, starting at off.
+func (s *Scanner) read(off int, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	got, err := s.r.ReadAt(buf, int64(off))
+	if got < n {
+		return nil, fmt.Errorf("failed to read game: off=%d len=%d: %v",
+			off, n, err)
+	}
+
+	return buf, nil
+}
+
+// NumBlocks returns the number of blocks in the game.
+func (s *Scanner) NumBlocks() int {
+	return len(s.Index)
+}
+
+// Block reads and decrypts a single block.  The block's secure section
+// boundary is resolved the first time it is read and remembered for later
+// calls.
+func (s *Scanner) Block(idx int) (*Desc, error) {
+	if idx < 0 || idx >= len(s.Index) {
+		return nil, fmt.Errorf(
+			"invalid block index: have=%d want>=0&&<%d", idx, len(s.Index))
+	}
+	e := s.Index[idx]
+
+	raw, err := s.read(e.Offset+HeaderLen, e.BodyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	bnd := s.bounds[idx]
+	if bnd == nil {
+		isMap := idx < s.numMapBlocks
+		secLen, cands := resolveBoundary(raw, e.Hdr, idx, isMap, s.validate)
+
+		bnd = &boundary{
+			secLen: secLen,
+			cands:  cands,
+		}
+		s.bounds[idx] = bnd
+	}
+
+	return &Desc{
+		Offset:     e.Offset,
+		Hdr:        e.Hdr,
+		Body:       *splitBody(raw, e.Hdr, bnd.secLen),
+		Candidates: bnd.cands,
+	}, nil
+}
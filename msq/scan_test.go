@@ -0,0 +1,116 @@
+package msq
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestScannerMatchesParseGame(t *testing.T) {
+	bodies := []Body{
+		{
+			SecSection:   []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			PlainSection: []byte{0x20, 0x65, 9, 10},
+		},
+		{
+			SecSection:   []byte{0x21, 0x22, 0x23},
+			PlainSection: []byte{0x20, 0x65},
+		},
+		{
+			SecSection:   []byte{11, 12, 13, 14},
+			PlainSection: []byte{15, 16},
+		},
+	}
+
+	var game []byte
+	for _, b := range bodies {
+		game = append(game, EncodeMsqBlock(b, 1)...)
+	}
+
+	descs, err := ParseGame(game, 2)
+	if err != nil {
+		t.Fatalf("failed to parse game: %v", err)
+	}
+
+	s, err := NewScanner(bytes.NewReader(game), len(game), 2, nil)
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+	if s.NumBlocks() != len(descs) {
+		t.Fatalf("wrong block count: have=%d want=%d",
+			s.NumBlocks(), len(descs))
+	}
+
+	// Access blocks out of order.
+	for _, i := range []int{2, 0, 1} {
+		d, err := s.Block(i)
+		if err != nil {
+			t.Fatalf("failed to read block %d: %v", i, err)
+		}
+
+		if d.Offset != descs[i].Offset || d.Hdr != descs[i].Hdr ||
+			!bytes.Equal(d.Body.SecSection, descs[i].Body.SecSection) ||
+			!bytes.Equal(d.Body.PlainSection, descs[i].Body.PlainSection) {
+
+			t.Errorf("block %d mismatch: have=%+v want=%+v",
+				i, *d, descs[i])
+		}
+	}
+
+	if _, err := s.Block(3); err == nil {
+		t.Errorf("out of range block read without error")
+	}
+}
+
+func TestScannerLazyBoundaries(t *testing.T) {
+	bodies := []Body{
+		{SecSection: []byte{1, 2, 3}, PlainSection: []byte{4, 5}},
+		{SecSection: []byte{6, 7, 8}, PlainSection: []byte{9}},
+	}
+
+	var game []byte
+	for _, b := range bodies {
+		game = append(game, EncodeMsqBlock(b, 0)...)
+	}
+
+	var validated []int
+	validate := func(blockIdx int, body Body) bool {
+		validated = append(validated, blockIdx)
+		return true
+	}
+
+	s, err := NewScanner(bytes.NewReader(game), len(game), 0, validate)
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+	if len(validated) != 0 {
+		t.Fatalf("boundaries resolved during indexing: %v", validated)
+	}
+
+	for i := 0; i < 2; i++ {
+		d, err := s.Block(1)
+		if err != nil {
+			t.Fatalf("failed to read block: %v", err)
+		}
+		if !bytes.Equal(d.Body.SecSection, bodies[1].SecSection) {
+			t.Fatalf("wrong secure section: have=%x want=%x",
+				d.Body.SecSection, bodies[1].SecSection)
+		}
+	}
+
+	if len(validated) == 0 {
+		t.Fatalf("validator never called")
+	}
+	for _, idx := range validated {
+		if idx != 1 {
+			t.Fatalf("unrequested block resolved: %v", validated)
+		}
+	}
+	n := len(validated)
+
+	if _, err := s.Block(1); err != nil {
+		t.Fatalf("failed to read block: %v", err)
+	}
+	if len(validated) != n {
+		t.Fatalf("boundary resolved more than once")
+	}
+}
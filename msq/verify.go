@@ -0,0 +1,80 @@
+package msq
+
+// BlockVerification is the result of checking a single MSQ block's integrity.
+type BlockVerification struct {
+	BlockIdx     int    // Index of the block; set by VerifyGame.
+	Offset       int    // Offset of the block header within its GAMEx file.
+	HeaderCsum   uint16 // Checksum recorded in the block header.
+	ComputedCsum uint16 // Checksum calculated over the secure section.
+
+	// MismatchOff is the offset within the secure section of the first byte
+	// after which the running checksum never again equals the header
+	// checksum.  It locates the end of the data the header's checksum
+	// actually covers.  It is -1 if the block is intact, and 0 if no prefix
+	// of the secure section matches the header checksum.
+	MismatchOff int
+}
+
+// OK indicates whether the block passes the game's integrity check.
+func (v *BlockVerification) OK() bool {
+	return v.HeaderCsum == v.ComputedCsum
+}
+
+// VerifyBlock checks a single block's secure section against the checksum in
+// its header.  The result is only as independent as d's secure section
+// boundary: ParseGame chooses the boundary by matching the header checksum, so
+// a block it parsed normally passes.  See VerifyGame.
+func VerifyBlock(d Desc) BlockVerification {
+	v := BlockVerification{
+		Offset:       d.Offset,
+		HeaderCsum:   d.Hdr.Checksum(),
+		ComputedCsum: CalcChecksum(d.Body.SecSection),
+		MismatchOff:  -1,
+	}
+
+	if v.OK() {
+		return v
+	}
+
+	// Find the last point at which the running checksum matched.
+	v.MismatchOff = 0
+	csum := uint16(0)
+	for i, b := range d.Body.SecSection {
+		if csum == v.HeaderCsum {
+			v.MismatchOff = i
+		}
+		csum -= uint16(b)
+	}
+
+	return v
+}
+
+// VerifyGame checks the integrity of every block in a GAMEx file.  It returns
+// the verification results of the blocks that fail the check.  numMapBlocks
+// has the same meaning as in ParseGame.
+//
+// The secure section's length is not stored anywhere; ParseGame finds it by
+// looking for the point at which the running checksum equals the header
+// checksum.  VerifyGame uses the same boundaries, so it can only detect a
+// block for which no such point exists (ParseGame then treats the whole body
+// as the secure section).  Corruption that happens to produce a checksum match
+// elsewhere in the block goes undetected, so an empty result does not
+// guarantee that the file is intact.
+func VerifyGame(game []byte, numMapBlocks int) ([]BlockVerification, error) {
+	descs, err := ParseGame(game, numMapBlocks)
+	if err != nil {
+		return nil, err
+	}
+
+	var fails []BlockVerification
+	for i, d := range descs {
+		v := VerifyBlock(d)
+		v.BlockIdx = i
+
+		if !v.OK() {
+			fails = append(fails, v)
+		}
+	}
+
+	return fails, nil
+}
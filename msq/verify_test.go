@@ -0,0 +1,50 @@
+package msq
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecryptInvertsEncrypt(t *testing.T) {
+	plain := []byte{0, 1, 2, 0x20, 0x65, 0xff}
+
+	enc := Encrypt(plain, 0x12, 0x34)
+	if dec := Decrypt(enc, 0x12, 0x34); !bytes.Equal(dec, plain) {
+		t.Fatalf("decrypt mismatch: have=%x want=%x", dec, plain)
+	}
+}
+
+func TestVerifyGame(t *testing.T) {
+	bodies := []Body{
+		{SecSection: []byte{1, 2, 3, 4}, PlainSection: []byte{5}},
+		{SecSection: []byte{6, 7, 8, 9}, PlainSection: []byte{10}},
+	}
+
+	var game []byte
+	for _, b := range bodies {
+		game = append(game, EncodeMsqBlock(b, 0)...)
+	}
+
+	fails, err := VerifyGame(game, 0)
+	if err != nil {
+		t.Fatalf("failed to verify game: %v", err)
+	}
+	if len(fails) != 0 {
+		t.Fatalf("intact game reported corrupt: %+v", fails)
+	}
+
+	// Corrupt the second byte of the second block's secure section.
+	off := HeaderLen + 5 + HeaderLen + 1
+	game[off] ^= 0x40
+
+	fails, err = VerifyGame(game, 0)
+	if err != nil {
+		t.Fatalf("failed to verify game: %v", err)
+	}
+	if len(fails) != 1 || fails[0].BlockIdx != 1 {
+		t.Fatalf("corrupt block not reported: %+v", fails)
+	}
+	if fails[0].OK() || fails[0].HeaderCsum == fails[0].ComputedCsum {
+		t.Fatalf("corrupt block has matching checksums: %+v", fails[0])
+	}
+}
@@ -0,0 +1,440 @@
+// Package patch records semantic changes to a decoded game so that they can
+// be distributed and applied independently of the full GAME files.
+//
+// A patch is a list of diff.Change values.  Each change carries the value it
+// expects to find (Old) as well as the value to write (New), so a patch only
+// applies to a game that matches its base.  Patches that touch different
+// fields can be stacked.
+package patch
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/diff"
+	"github.com/badvassal/wllib/gen/wlerr"
+	"github.com/badvassal/wllib/msq"
+	"github.com/badvassal/wllib/wlutil"
+)
+
+// FormatVersion is the version of the patch file format written by Marshal.
+const FormatVersion = 1
+
+// Patch is a set of changes to a decode state.  Changes are applied in order.
+type Patch struct {
+	Format  int           `json:"format"`
+	Changes []diff.Change `json:"changes"`
+}
+
+// normalize reorders changes so that they can be applied one at a time.  The
+// differ lists elements removed from the end of a slice in ascending order,
+// but each removal must take away the last element.
+func normalize(changes []diff.Change) []diff.Change {
+	out := append([]diff.Change(nil), changes...)
+
+	for i := 0; i < len(out); {
+		if out[i].Kind != diff.KindRemoved {
+			i++
+			continue
+		}
+
+		j := i + 1
+		for j < len(out) && out[j].Kind == diff.KindRemoved &&
+			out[j].Game == out[i].Game && out[j].Block == out[i].Block &&
+			out[j].Section == out[i].Section &&
+			parentPath(out[j].Path) == parentPath(out[i].Path) {
+
+			j++
+		}
+
+		for l, r := i, j-1; l < r; l, r = l+1, r-1 {
+			out[l], out[r] = out[r], out[l]
+		}
+		i = j
+	}
+
+	return out
+}
+
+// deepCopy duplicates a change value.  The differ's values share memory with
+// the states they were taken from; a patch must not.
+func deepCopy(val interface{}) (interface{}, error) {
+	if val == nil {
+		return nil, nil
+	}
+
+	v, err := convert(val, reflect.TypeOf(val))
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Interface(), nil
+}
+
+// Make creates a patch that transforms base into modded.  The patch's values
+// are copies; later modifications to either state don't affect it.
+func Make(base decode.DecodeState, modded decode.DecodeState) (*Patch, error) {
+	changes := normalize(diff.DiffStates(base, modded))
+
+	for i := range changes {
+		c := &changes[i]
+
+		var err error
+		if c.Old, err = deepCopy(c.Old); err != nil {
+			return nil, wlerr.Wrapf(err, "failed to copy change %d", i)
+		}
+		if c.New, err = deepCopy(c.New); err != nil {
+			return nil, wlerr.Wrapf(err, "failed to copy change %d", i)
+		}
+	}
+
+	return &Patch{
+		Format:  FormatVersion,
+		Changes: changes,
+	}, nil
+}
+
+// inverseChange produces a change that undoes c.
+func inverseChange(c diff.Change) diff.Change {
+	c.Old, c.New = c.New, c.Old
+
+	switch c.Kind {
+	case diff.KindAdded:
+		c.Kind = diff.KindRemoved
+	case diff.KindRemoved:
+		c.Kind = diff.KindAdded
+	}
+
+	return c
+}
+
+// Inverse creates a patch that undoes p.
+func (p *Patch) Inverse() *Patch {
+	inv := &Patch{
+		Format: p.Format,
+	}
+
+	for i := len(p.Changes) - 1; i >= 0; i-- {
+		inv.Changes = append(inv.Changes, inverseChange(p.Changes[i]))
+	}
+
+	return inv
+}
+
+// Marshal encodes a patch as JSON.
+func Marshal(p Patch) ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// Unmarshal decodes a JSON patch.
+func Unmarshal(data []byte) (*Patch, error) {
+	p := &Patch{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, wlerr.Wrapf(err, "failed to decode patch")
+	}
+
+	if p.Format != FormatVersion {
+		return nil, wlerr.Errorf("unsupported patch format: have=%d want=%d",
+			p.Format, FormatVersion)
+	}
+
+	return p, nil
+}
+
+// pathElem is a single step in a change path: either a field name or a slice
+// index.
+type pathElem struct {
+	field string
+	index int
+}
+
+// parentPath strips the final element from a change path.
+func parentPath(path string) string {
+	i := strings.LastIndexAny(path, ".[")
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+// parsePath splits a change path (e.g., "NPCs[2].Strength") into its
+// elements.
+func parsePath(path string) ([]pathElem, error) {
+	var elems []pathElem
+
+	for path != "" {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, wlerr.Errorf("unterminated index in path")
+			}
+			idx, err := strconv.Atoi(path[1:end])
+			if err != nil || idx < 0 {
+				return nil, wlerr.Errorf("invalid index in path: %q",
+					path[1:end])
+			}
+			elems = append(elems, pathElem{index: idx})
+			path = path[end+1:]
+
+		default:
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			elems = append(elems, pathElem{field: path[:end]})
+			path = path[end:]
+		}
+	}
+
+	return elems, nil
+}
+
+// step descends from v into a single path element.  Pointers are followed
+// transparently.
+func step(v reflect.Value, e pathElem) (reflect.Value, error) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}, wlerr.Errorf("nil pointer in path")
+		}
+		v = v.Elem()
+	}
+
+	if e.field != "" {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, wlerr.Errorf(
+				"field %q applied to non-struct", e.field)
+		}
+		sf, ok := v.Type().FieldByName(e.field)
+		if !ok || sf.PkgPath != "" {
+			return reflect.Value{}, wlerr.Errorf("no such field: %q", e.field)
+		}
+		return v.FieldByIndex(sf.Index), nil
+	}
+
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return reflect.Value{}, wlerr.Errorf(
+			"index %d applied to non-slice", e.index)
+	}
+	if e.index >= v.Len() {
+		return reflect.Value{}, wlerr.Errorf(
+			"index out of range: have=%d want<%d", e.index, v.Len())
+	}
+	return v.Index(e.index), nil
+}
+
+// convert turns a change value into a new value of type t.  Values from a
+// patch that was read from JSON are generic (maps, float64s, etc.), so they are
+// re-encoded and decoded into the target type.  Typed values go through the
+// same process so that the result never shares memory with val.
+func convert(val interface{}, t reflect.Type) (reflect.Value, error) {
+	if val == nil {
+		return reflect.Zero(t), nil
+	}
+
+	b, err := json.Marshal(val)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	p := reflect.New(t)
+	if err := json.Unmarshal(b, p.Interface()); err != nil {
+		return reflect.Value{}, wlerr.Wrapf(err,
+			"value does not fit type %s", t)
+	}
+
+	return p.Elem(), nil
+}
+
+// sectionValue retrieves the section of a decode state that a change refers
+// to.
+func sectionValue(s *decode.DecodeState, c diff.Change) (reflect.Value, error) {
+	if c.Block == diff.BlockSaveState {
+		if c.Section != diff.SectionSaveState {
+			return reflect.Value{}, wlerr.Errorf(
+				"invalid save state section: %q", c.Section)
+		}
+		return reflect.ValueOf(&s.SaveState).Elem(), nil
+	}
+
+	if c.Game < 0 || c.Game >= len(s.Blocks) ||
+		c.Block < 0 || c.Block >= len(s.Blocks[c.Game]) {
+
+		return reflect.Value{}, wlerr.Errorf("no such block")
+	}
+
+	if c.Section == "" {
+		return reflect.Value{}, wlerr.Errorf(
+			"adding or removing whole blocks is not supported")
+	}
+
+	b := reflect.ValueOf(&s.Blocks[c.Game][c.Block]).Elem()
+	v := b.FieldByName(c.Section)
+	if !v.IsValid() {
+		return reflect.Value{}, wlerr.Errorf("no such section: %q", c.Section)
+	}
+
+	return v, nil
+}
+
+// applyChange applies a single change to a decode state.  The state is left
+// untouched if the change's base value does not match.
+func applyChange(s *decode.DecodeState, c diff.Change) error {
+	v, err := sectionValue(s, c)
+	if err != nil {
+		return err
+	}
+
+	elems, err := parsePath(c.Path)
+	if err != nil {
+		return err
+	}
+
+	if c.Kind != diff.KindChanged {
+		// Added and removed elements are handled through their slice.
+		if len(elems) == 0 || elems[len(elems)-1].field != "" {
+			return wlerr.Errorf("element added or removed outside a slice")
+		}
+		last := elems[len(elems)-1]
+
+		for _, e := range elems[:len(elems)-1] {
+			v, err = step(v, e)
+			if err != nil {
+				return err
+			}
+		}
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Slice {
+			return wlerr.Errorf("element added to or removed from non-slice")
+		}
+
+		return applySliceChange(v, last.index, c)
+	}
+
+	for _, e := range elems {
+		v, err = step(v, e)
+		if err != nil {
+			return err
+		}
+	}
+
+	oldVal, err := convert(c.Old, v.Type())
+	if err != nil {
+		return err
+	}
+	newVal, err := convert(c.New, v.Type())
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(v.Interface(), oldVal.Interface()) {
+		return wlerr.Errorf("base value mismatch: have=%+v want=%+v",
+			v.Interface(), oldVal.Interface())
+	}
+
+	v.Set(newVal)
+	return nil
+}
+
+// applySliceChange adds an element to the end of a slice or removes its final
+// element.
+func applySliceChange(v reflect.Value, idx int, c diff.Change) error {
+	switch c.Kind {
+	case diff.KindAdded:
+		if idx != v.Len() {
+			return wlerr.Errorf("element added at wrong index: have=%d want=%d",
+				idx, v.Len())
+		}
+
+		newVal, err := convert(c.New, v.Type().Elem())
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, newVal))
+
+	case diff.KindRemoved:
+		if idx != v.Len()-1 {
+			return wlerr.Errorf(
+				"element removed at wrong index: have=%d want=%d",
+				idx, v.Len()-1)
+		}
+
+		oldVal, err := convert(c.Old, v.Type().Elem())
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(v.Index(idx).Interface(), oldVal.Interface()) {
+			return wlerr.Errorf("base value mismatch: have=%+v want=%+v",
+				v.Index(idx).Interface(), oldVal.Interface())
+		}
+		v.Set(v.Slice(0, idx))
+
+	default:
+		return wlerr.Errorf("invalid change kind: %q", c.Kind)
+	}
+
+	return nil
+}
+
+// ApplyState applies a patch to a decode state.  It fails if any of the
+// patch's base values don't match the state.  Either every change is applied
+// or the state is left untouched.
+func ApplyState(p Patch, s *decode.DecodeState) error {
+	for i, c := range p.Changes {
+		if err := applyChange(s, c); err != nil {
+			// Undo the changes that were already applied.
+			for j := i - 1; j >= 0; j-- {
+				uerr := applyChange(s, inverseChange(p.Changes[j]))
+				if uerr != nil {
+					return wlerr.Wrapf(uerr,
+						"failed to roll back patch: change=%d", j)
+				}
+			}
+
+			return wlerr.Wrapf(err, "failed to apply patch: change=%d (%s)",
+				i, c)
+		}
+	}
+
+	return nil
+}
+
+// RevertState undoes a patch that was applied to a decode state.
+func RevertState(p Patch, s *decode.DecodeState) error {
+	return ApplyState(*p.Inverse(), s)
+}
+
+// Apply applies a patch to a pair of MSQ block sequences (GAME1 and GAME2).
+// The blocks are decoded, patched, and written back via
+// wlutil.CommitDecodeState.  The block sequences are only modified if the
+// whole patch can be applied and committed.
+func Apply(p Patch, bodies1 []msq.Body, bodies2 []msq.Body) error {
+	state, err := wlutil.DecodeGames(bodies1, bodies2)
+	if err != nil {
+		return err
+	}
+
+	if err := ApplyState(p, state); err != nil {
+		return err
+	}
+
+	if err := wlutil.CommitDecodeState(*state, bodies1, bodies2); err != nil {
+		return wlerr.Wrapf(err, "failed to commit patch")
+	}
+
+	return nil
+}
+
+// Revert undoes a patch that was applied to a pair of MSQ block sequences.
+// See Apply.
+func Revert(p Patch, bodies1 []msq.Body, bodies2 []msq.Body) error {
+	return Apply(*p.Inverse(), bodies1, bodies2)
+}
@@ -0,0 +1,125 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/diff"
+)
+
+func makeState() decode.DecodeState {
+	return decode.DecodeState{
+		Blocks: [][]decode.Block{
+			{
+				decode.Block{
+					NPCTable: decode.NPCTable{
+						NPCs: []decode.Character{
+							{Name: "Bob", Strength: 12, Raw: []byte{1, 2}},
+						},
+					},
+					ActionTables: action.Tables{
+						Loots: []*action.Loot{
+							nil,
+							&action.Loot{
+								Items: []action.LootItem{{ID: 1}, {ID: 2}},
+							},
+						},
+					},
+				},
+			},
+			nil,
+		},
+	}
+}
+
+func TestPatchApplyRevert(t *testing.T) {
+	base := makeState()
+	modded := makeState()
+	mb := &modded.Blocks[0][0]
+	mb.NPCTable.NPCs[0].Strength = 15
+	mb.ActionTables.Loots[0] = &action.Loot{ToClass: 1}
+	mb.ActionTables.Loots[1].Items = nil
+
+	// Round trip the patch through its file format.
+	made, err := Make(base, modded)
+	if err != nil {
+		t.Fatalf("failed to make patch: %v", err)
+	}
+	data, err := Marshal(*made)
+	if err != nil {
+		t.Fatalf("failed to marshal patch: %v", err)
+	}
+	p, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("failed to unmarshal patch: %v", err)
+	}
+
+	s := makeState()
+	if err := ApplyState(*p, &s); err != nil {
+		t.Fatalf("failed to apply patch: %v", err)
+	}
+	if cs := diff.DiffStates(s, modded); len(cs) != 0 {
+		t.Fatalf("patched state differs from modded state: %v", cs)
+	}
+
+	// The patch no longer applies; its base values have changed.
+	if err := ApplyState(*p, &s); err == nil {
+		t.Fatalf("patch applied twice without error")
+	}
+	if cs := diff.DiffStates(s, modded); len(cs) != 0 {
+		t.Fatalf("failed patch modified state: %v", cs)
+	}
+
+	// A mismatch in a later change rolls back the earlier ones.
+	other := makeState()
+	other.Blocks[0][0].NPCTable.NPCs[0].Strength = 13
+	if err := ApplyState(*p, &other); err == nil {
+		t.Fatalf("patch applied to mismatched base without error")
+	}
+	want := makeState()
+	want.Blocks[0][0].NPCTable.NPCs[0].Strength = 13
+	if cs := diff.DiffStates(other, want); len(cs) != 0 {
+		t.Fatalf("failed patch modified state: %v", cs)
+	}
+
+	if err := RevertState(*p, &s); err != nil {
+		t.Fatalf("failed to revert patch: %v", err)
+	}
+	if cs := diff.DiffStates(s, base); len(cs) != 0 {
+		t.Fatalf("reverted state differs from base state: %v", cs)
+	}
+}
+
+func TestMakeCopiesValues(t *testing.T) {
+	base := makeState()
+	modded := makeState()
+	mb := &modded.Blocks[0][0]
+	mb.ActionTables.Loots[0] = &action.Loot{ToClass: 1}
+	mb.CentralDir.NPCTable = 0x1234
+
+	p, err := Make(base, modded)
+	if err != nil {
+		t.Fatalf("failed to make patch: %v", err)
+	}
+	if len(p.Changes) != 1 {
+		t.Fatalf("wrong changes: %v", p.Changes)
+	}
+
+	// Modifying the state after the fact must not alter the patch.
+	mb.ActionTables.Loots[0].ToClass = 2
+
+	s := makeState()
+	if err := ApplyState(*p, &s); err != nil {
+		t.Fatalf("failed to apply patch: %v", err)
+	}
+	if have := s.Blocks[0][0].ActionTables.Loots[0].ToClass; have != 1 {
+		t.Fatalf("patch shares memory with modded state: ToClass=%d", have)
+	}
+
+	// Nor may the patched state share memory with the patch.
+	s.Blocks[0][0].ActionTables.Loots[0].ToClass = 3
+	if have := p.Changes[0].New.(*action.Loot).ToClass; have != 1 {
+		t.Fatalf("patched state shares memory with patch: ToClass=%d", have)
+	}
+}
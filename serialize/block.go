@@ -0,0 +1,141 @@
+package serialize
+
+import (
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/gen/wlerr"
+	"github.com/badvassal/wllib/msq"
+)
+
+// maxSecSectionLen is the size limit of a secure section.  Central directory
+// pointers are 16 bits wide, so no area can start beyond this offset.
+const maxSecSectionLen = 0xffff
+
+// encodeCDArea encodes the area referenced by the specified central directory
+// pointer.  cdPtrIdx is the index of the area's pointer (see CDPtrIdx*).
+// baseOff is the offset of the start of the area relative to the start of the
+// secure section.  A nil return value indicates that the area is absent.
+func encodeCDArea(b decode.Block, cdPtrIdx int, baseOff int) ([]byte, error) {
+	switch cdPtrIdx {
+	case decode.CDPtrIdxNPCTable:
+		if len(b.NPCTable.NPCs) == 0 {
+			return nil, nil
+		}
+		return decode.EncodeNPCTable(b.NPCTable, baseOff)
+
+	case decode.CDPtrIdxSpecialActions:
+		sa := decode.EncodeSpecialActions(b.SpecialActions)
+		if len(sa) > 0 && b.Offsets.SpecialActions != 0 &&
+			baseOff != b.Offsets.SpecialActions {
+
+			return nil, wlerr.Errorf(
+				"special actions cannot be moved: have=%d want=%d",
+				baseOff, b.Offsets.SpecialActions)
+		}
+		return sa, nil
+
+	case decode.CDPtrIdxMonsterNames:
+		return decode.EncodeMonsterNames(b.MonsterNames), nil
+
+	case decode.CDPtrIdxMonsterData:
+		return decode.EncodeMonsterData(b.MonsterData), nil
+
+	default:
+		idx := decode.ActionTableIdx(cdPtrIdx)
+		if idx < 0 {
+			return nil, wlerr.Errorf(
+				"invalid central directory pointer index: %d", cdPtrIdx)
+		}
+
+		return SerializeActionTable(b.ActionTables, idx, baseOff), nil
+	}
+}
+
+// EncodeBlock encodes a decoded MSQ block from scratch.  The areas referenced
+// by the central directory are laid out contiguously in priority order (the
+// order they appear in the original GAME files) and every central directory
+// pointer is recalculated.  An absent area whose pointer is null in
+// b.CentralDir keeps its null pointer.  Any other absent area gets the same
+// pointer as the area that follows it, so it is recognized as zero-length
+// when the block is decoded.
+//
+// The special actions area cannot be moved (see decode.SpecialActions), so it
+// must end up at its original offset (b.Offsets.SpecialActions); otherwise
+// encoding fails.
+//
+// targetSize is the desired size of the body, in bytes (secure section plus
+// plain section).  If it is greater than zero, the plain section is padded
+// with zeros to reach it.  This allows an edited block to keep the size the
+// game expects.  It is an error for the encoded body to exceed a non-zero
+// targetSize.
+func EncodeBlock(b decode.Block, targetSize int) (*msq.Body, error) {
+	onErr := wlerr.MakeWrapper("failed to encode block")
+
+	if len(b.MapData.ActionClasses) == 0 {
+		return nil, onErr(nil, "block has no map data")
+	}
+
+	var sec []byte
+
+	sec = append(sec, decode.EncodeMapData(b.MapData)...)
+
+	// Reserve room for the central directory.  It gets filled in after all
+	// the pointers are known.
+	cdOff := len(sec)
+	sec = append(sec, make([]byte, decode.CentralDirLen)...)
+
+	sec = append(sec, decode.EncodeMapInfo(b.MapInfo)...)
+
+	cd := decode.CentralDir{
+		ActionTables: make([]int, 16),
+	}
+
+	origPtrs := b.CentralDir.Pointers()
+
+	for i := 0; i < decode.CDPtrIdxStrings; i++ {
+		off := len(sec)
+
+		area, err := encodeCDArea(b, i, off)
+		if err != nil {
+			return nil, onErr(err, "")
+		}
+
+		if len(area) == 0 && origPtrs[i] == 0 {
+			// The area was null in the original directory.  Keep it that
+			// way; the game may treat a null pointer specially.
+			continue
+		}
+
+		cd.SetPointer(i, off)
+		sec = append(sec, area...)
+	}
+
+	if len(sec) > maxSecSectionLen {
+		return nil, onErr(nil,
+			"secure section too large: have=%d want<=%d",
+			len(sec), maxSecSectionLen)
+	}
+
+	// The strings area is the first thing in the plain section.
+	cd.SetPointer(decode.CDPtrIdxStrings, len(sec))
+	copy(sec[cdOff:cdOff+decode.CentralDirLen], decode.EncodeCentralDir(cd))
+
+	var plain []byte
+	plain = append(plain, decode.EncodeStringsArea(b.StringsArea)...)
+	plain = append(plain, b.Trailer...)
+
+	if targetSize > 0 {
+		size := len(sec) + len(plain)
+		if size > targetSize {
+			return nil, onErr(nil,
+				"encoded block exceeds target size: have=%d want<=%d",
+				size, targetSize)
+		}
+
+		plain = append(plain, make([]byte, targetSize-size)...)
+	}
+
+	return &msq.Body{
+		SecSection:   sec,
+		PlainSection: plain,
+	}, nil
+}
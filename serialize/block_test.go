@@ -0,0 +1,250 @@
+package serialize
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/digest"
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/internal/blocktest"
+	"github.com/badvassal/wllib/msq"
+)
+
+func testBlock() decode.Block {
+	sa, err := digest.CompressStringsArea([][]byte{
+		[]byte("You see a door.\x00Locked.\x00\x00\x00"),
+		[]byte("Bob joins.\x00\x00\x00\x00"),
+	})
+	if err != nil {
+		panic(err.Error())
+	}
+
+	b := blocktest.Block()
+	b.ActionTables = action.Tables{
+		Loots: []*action.Loot{
+			&action.Loot{
+				ToClass: 0,
+				Items: []action.LootItem{
+					{Fixed: true, ID: 0x1c, Amount: 1},
+				},
+			},
+			nil,
+			&action.Loot{
+				ToClass: 5,
+				Cash:    &action.LootCash{Fixed: true, Amount: 100},
+			},
+		},
+		Transitions: []*action.Transition{
+			&action.Transition{
+				LocX:     3,
+				LocY:     7,
+				Location: 1,
+				ToClass:  0xff,
+			},
+		},
+	}
+	b.NPCTable = decode.NPCTable{
+		NPCs: []decode.Character{
+			decode.Character{Name: "Bob", Strength: 12},
+		},
+	}
+	b.MonsterNames = decode.MonsterNames{
+		Names: []decode.MonsterName{
+			{Start: "rat", MidPlural: "s"},
+		},
+	}
+	b.StringsArea = *sa
+	b.Trailer = []byte{0xaa, 0xbb}
+
+	return b
+}
+
+func TestEncodeBlockRoundTrip(t *testing.T) {
+	b := testBlock()
+
+	body, err := EncodeBlock(b, 0)
+	if err != nil {
+		t.Fatalf("failed to encode block: %v", err)
+	}
+
+	db, err := decode.DecodeBlock(*body, b.Dim)
+	if err != nil {
+		t.Fatalf("failed to decode encoded block: %v", err)
+	}
+
+	if len(db.ActionTables.Loots) != 3 || db.ActionTables.Loots[1] != nil {
+		t.Fatalf("loot table not preserved: %+v", db.ActionTables.Loots)
+	}
+	if db.NPCTable.NPCs[0].Name != "Bob" {
+		t.Fatalf("NPC not preserved: %+v", db.NPCTable.NPCs[0])
+	}
+	if !bytes.Equal(db.StringsArea.StringData, b.StringsArea.StringData) {
+		t.Fatalf("string data not preserved: have=%x want=%x",
+			db.StringsArea.StringData, b.StringsArea.StringData)
+	}
+	if !bytes.Equal(db.Trailer, b.Trailer) {
+		t.Fatalf("trailer not preserved: have=%v want=%v",
+			db.Trailer, b.Trailer)
+	}
+
+	body2, err := EncodeBlock(*db, 0)
+	if err != nil {
+		t.Fatalf("failed to re-encode block: %v", err)
+	}
+
+	if !bytes.Equal(body.SecSection, body2.SecSection) {
+		t.Fatalf("secure section changed after round trip")
+	}
+	if !bytes.Equal(body.PlainSection, body2.PlainSection) {
+		t.Fatalf("plain section changed after round trip")
+	}
+}
+
+func TestEncodeBlockTargetSize(t *testing.T) {
+	b := testBlock()
+
+	body, err := EncodeBlock(b, 0)
+	if err != nil {
+		t.Fatalf("failed to encode block: %v", err)
+	}
+	size := len(body.SecSection) + len(body.PlainSection)
+
+	padded, err := EncodeBlock(b, size+20)
+	if err != nil {
+		t.Fatalf("failed to encode padded block: %v", err)
+	}
+	have := len(padded.SecSection) + len(padded.PlainSection)
+	if have != size+20 {
+		t.Fatalf("wrong padded size: have=%d want=%d", have, size+20)
+	}
+
+	// The padding is decoded as part of the trailer.  Re-encoding the
+	// decoded block must reproduce the padded body.
+	db, err := decode.DecodeBlock(*padded, b.Dim)
+	if err != nil {
+		t.Fatalf("failed to decode padded block: %v", err)
+	}
+	wantTrailer := append(append([]byte(nil), b.Trailer...),
+		make([]byte, 20)...)
+	if !bytes.Equal(db.Trailer, wantTrailer) {
+		t.Fatalf("wrong trailer: have=%v want=%v", db.Trailer, wantTrailer)
+	}
+
+	padded2, err := EncodeBlock(*db, size+20)
+	if err != nil {
+		t.Fatalf("failed to re-encode padded block: %v", err)
+	}
+	if !bytes.Equal(padded.PlainSection, padded2.PlainSection) {
+		t.Fatalf("plain section changed after round trip")
+	}
+
+	if _, err := EncodeBlock(b, size-1); err == nil {
+		t.Fatalf("oversized block encoded without error")
+	}
+}
+
+func TestSerializeGameVerified(t *testing.T) {
+	b := testBlock()
+
+	body, err := EncodeBlock(b, 0)
+	if err != nil {
+		t.Fatalf("failed to encode block: %v", err)
+	}
+
+	other := msq.Body{SecSection: []byte{1, 2, 3}, PlainSection: []byte{4}}
+	bodies := []msq.Body{*body, other}
+
+	game, err := SerializeGameVerified(bodies, 0, []gen.Point{b.Dim}, nil)
+	if err != nil {
+		t.Fatalf("failed to serialize game: %v", err)
+	}
+
+	descs, err := msq.ParseGame(game, 1)
+	if err != nil {
+		t.Fatalf("failed to parse serialized game: %v", err)
+	}
+	if len(descs) != len(bodies) {
+		t.Fatalf("wrong block count: have=%d want=%d",
+			len(descs), len(bodies))
+	}
+	for i, d := range descs {
+		if !bytes.Equal(d.Body.SecSection, bodies[i].SecSection) ||
+			!bytes.Equal(d.Body.PlainSection, bodies[i].PlainSection) {
+
+			t.Fatalf("block %d changed after reparse", i)
+		}
+	}
+}
+
+func TestSerializeGameVerifiedValidate(t *testing.T) {
+	// The tail of the secure section sums to zero, so the checksum also
+	// matches at the start of the tail.  Only the validator can tell the
+	// two boundaries apart.
+	sec := []byte{5, 5, 5, 5}
+	sec = append(sec, 0x01)
+	for i := 0; i < 0xff+2; i++ {
+		sec = append(sec, 0xff)
+	}
+	sec = append(sec, make([]byte, 0x400-len(sec))...)
+
+	bodies := []msq.Body{
+		msq.Body{SecSection: sec, PlainSection: []byte{0x80, 0x81, 0x82}},
+	}
+
+	if _, err := SerializeGameVerified(bodies, 0, nil, nil); err == nil {
+		t.Fatalf("misparsing block serialized without error")
+	}
+
+	validate := func(blockIdx int, body msq.Body) bool {
+		return len(body.SecSection) >= 0x400
+	}
+
+	game, err := SerializeGameVerified(bodies, 0, nil, validate)
+	if err != nil {
+		t.Fatalf("failed to serialize game: %v", err)
+	}
+
+	descs, err := msq.ParseGameValidate(game, 0, validate)
+	if err != nil {
+		t.Fatalf("failed to parse serialized game: %v", err)
+	}
+	if !bytes.Equal(descs[0].Body.SecSection, sec) {
+		t.Fatalf("secure section changed after reparse: have=%d want=%d",
+			len(descs[0].Body.SecSection), len(sec))
+	}
+}
+
+func TestEncodeBlockNullPointers(t *testing.T) {
+	b := blocktest.Block()
+
+	body, err := EncodeBlock(b, 0)
+	if err != nil {
+		t.Fatalf("failed to encode block: %v", err)
+	}
+
+	db, err := decode.DecodeBlock(*body, b.Dim)
+	if err != nil {
+		t.Fatalf("failed to decode block: %v", err)
+	}
+	if db.CentralDir.NPCTable != 0 || db.CentralDir.SpecialActions != 0 {
+		t.Fatalf("absent areas got non-null pointers: %+v", db.CentralDir)
+	}
+
+	// An absent area whose pointer wasn't null keeps aliasing the next
+	// area.
+	db.CentralDir.NPCTable = db.CentralDir.MonsterData
+	body, err = EncodeBlock(*db, 0)
+	if err != nil {
+		t.Fatalf("failed to re-encode block: %v", err)
+	}
+
+	db2, err := decode.DecodeBlock(*body, b.Dim)
+	if err != nil {
+		t.Fatalf("failed to decode re-encoded block: %v", err)
+	}
+	if db2.CentralDir.NPCTable == 0 || db2.CentralDir.SpecialActions != 0 {
+		t.Fatalf("wrong pointers after re-encoding: %+v", db2.CentralDir)
+	}
+}
@@ -1,8 +1,12 @@
 package serialize
 
 import (
+	"strconv"
+
+	"github.com/badvassal/wllib/decode"
 	"github.com/badvassal/wllib/decode/action"
 	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
 	"github.com/badvassal/wllib/msq"
 )
 
@@ -29,18 +33,117 @@ func SerializeActionTransitions(ats []*action.Transition, baseOff int) []byte {
 	return data
 }
 
-func SerializeActionLoots(loots []*action.Loot, baseOff int) []byte {
+// serializeTable encodes a typed action table to a byte sequence.  n is the
+// number of elements in the table; encode returns the encoded form of element
+// i, or nil if the element is absent.  Absent elements are encoded as null
+// pointers so that the selectors of subsequent elements are preserved.
+// baseOff is the offset of the start of the table relative to the start of
+// the secure section.
+func serializeTable(n int, encode func(i int) []byte, baseOff int) []byte {
 	t := gen.Table{}
 
-	for _, loot := range loots {
-		if loot != nil {
-			t.Elems = append(t.Elems, action.EncodeLoot(*loot))
-		}
+	for i := 0; i < n; i++ {
+		t.Elems = append(t.Elems, encode(i))
 	}
 
 	return t.Encode(baseOff)
 }
 
+// SerializeActionLoots encodes a set of loot bags to a byte sequence.
+// baseOff is the offset of the start of the loot table relative to the start
+// of the secure section.  Nil loot bags are encoded as null pointers so that
+// the selectors of subsequent bags are preserved.
+func SerializeActionLoots(loots []*action.Loot, baseOff int) []byte {
+	return serializeTable(len(loots), func(i int) []byte {
+		if loots[i] == nil {
+			return nil
+		}
+		return action.EncodeLoot(*loots[i])
+	}, baseOff)
+}
+
+// SerializeActionTable encodes a single action table to a byte sequence.  idx
+// is the index of the table to encode (0-15).  baseOff is the offset of the
+// start of the table relative to the start of the secure section.
+func SerializeActionTable(tables action.Tables, idx int, baseOff int) []byte {
+	switch idx {
+	case 0:
+		return tables.T0.Encode(baseOff)
+	case action.IDPrint:
+		return serializeTable(len(tables.Prints), func(i int) []byte {
+			if tables.Prints[i] == nil {
+				return nil
+			}
+			return action.EncodePrint(*tables.Prints[i])
+		}, baseOff)
+	case action.IDCheck:
+		return serializeTable(len(tables.Checks), func(i int) []byte {
+			if tables.Checks[i] == nil {
+				return nil
+			}
+			return action.EncodeCheck(*tables.Checks[i])
+		}, baseOff)
+	case action.IDPassword:
+		return serializeTable(len(tables.Passwords), func(i int) []byte {
+			if tables.Passwords[i] == nil {
+				return nil
+			}
+			return action.EncodePassword(*tables.Passwords[i])
+		}, baseOff)
+	case action.IDAlteration:
+		return serializeTable(len(tables.Alterations), func(i int) []byte {
+			if tables.Alterations[i] == nil {
+				return nil
+			}
+			return action.EncodeAlteration(*tables.Alterations[i])
+		}, baseOff)
+	case action.IDLoot:
+		return SerializeActionLoots(tables.Loots, baseOff)
+	case action.IDShop:
+		return serializeTable(len(tables.Shops), func(i int) []byte {
+			if tables.Shops[i] == nil {
+				return nil
+			}
+			return action.EncodeShop(*tables.Shops[i])
+		}, baseOff)
+	case action.IDImpassable:
+		return serializeTable(len(tables.Impassables), func(i int) []byte {
+			if tables.Impassables[i] == nil {
+				return nil
+			}
+			return action.EncodeImpassable(*tables.Impassables[i])
+		}, baseOff)
+	case 8:
+		return tables.T8.Encode(baseOff)
+	case 9:
+		return tables.T9.Encode(baseOff)
+	case action.IDTransition:
+		return SerializeActionTransitions(tables.Transitions, baseOff)
+	case action.IDDialogue:
+		return serializeTable(len(tables.Dialogues), func(i int) []byte {
+			if tables.Dialogues[i] == nil {
+				return nil
+			}
+			return action.EncodeDialogue(*tables.Dialogues[i])
+		}, baseOff)
+	case 12:
+		return tables.T12.Encode(baseOff)
+	case action.IDEncounter:
+		return serializeTable(len(tables.Encounters), func(i int) []byte {
+			if tables.Encounters[i] == nil {
+				return nil
+			}
+			return action.EncodeEncounter(*tables.Encounters[i])
+		}, baseOff)
+	case 14:
+		return tables.T14.Encode(baseOff)
+	case 15:
+		return tables.T15.Encode(baseOff)
+	default:
+		panic("invalid action table index: " + strconv.Itoa(idx))
+	}
+}
+
 // SerializeActionTables encodes the set of action tables to a byte sequence.
 // baseOff is the offset of the start of the first action table relative to the
 // start of the secure section.
@@ -49,32 +152,12 @@ func SerializeActionTables(tables action.Tables, baseOff int) []byte {
 
 	off := baseOff
 
-	appendBlob := func(blob []byte) {
+	for i := 0; i < 16; i++ {
+		blob := SerializeActionTable(tables, i, off)
 		b = append(b, blob...)
 		off += len(blob)
 	}
 
-	appendGenTable := func(t gen.Table) {
-		appendBlob(t.Encode(off))
-	}
-
-	appendGenTable(tables.T0)
-	appendGenTable(tables.T1)
-	appendGenTable(tables.T2)
-	appendGenTable(tables.T3)
-	appendGenTable(tables.T4)
-	appendBlob(SerializeActionLoots(tables.Loots, off))
-	appendGenTable(tables.T6)
-	appendGenTable(tables.T7)
-	appendGenTable(tables.T8)
-	appendGenTable(tables.T9)
-	appendBlob(SerializeActionTransitions(tables.Transitions, off))
-	appendGenTable(tables.T11)
-	appendGenTable(tables.T12)
-	appendGenTable(tables.T13)
-	appendGenTable(tables.T14)
-	appendGenTable(tables.T15)
-
 	return b
 }
 
@@ -90,3 +173,81 @@ func SerializeGame(bodies []msq.Body, gameIdx int) []byte {
 
 	return out
 }
+
+// mapPaddingOff calculates the offset of a padding byte in a map block's
+// secure section.  The byte is the first of the two unused bytes at the start
+// of the map info area; the game ignores its value.
+func mapPaddingOff(dim gen.Point) int {
+	return decode.MapDataLen(dim) + decode.CentralDirLen
+}
+
+// serializeBlockVerified encrypts a single MSQ block and ensures it parses
+// back to the same body.  If a map block would be split in the wrong place,
+// its padding byte is adjusted until the block parses correctly.  dim is nil
+// for non-map blocks; such blocks have no padding to adjust.  blockIdx and
+// validate are passed to the parser (see msq.ParseGameValidate).  It returns
+// the encrypted block and the body it encodes, which differs from body if the
+// padding was adjusted.
+func serializeBlockVerified(body msq.Body, gameIdx int, blockIdx int,
+	dim *gen.Point, validate msq.BoundaryValidator) ([]byte, *msq.Body, error) {
+
+	out := msq.EncodeMsqBlock(body, gameIdx)
+	err := msq.VerifyMsqBlock(out, body, blockIdx, dim != nil, validate)
+	if err == nil {
+		return out, &body, nil
+	}
+	if dim == nil {
+		return nil, nil, err
+	}
+
+	padOff := mapPaddingOff(*dim)
+	if padOff >= len(body.SecSection) {
+		return nil, nil, wlerr.Wrapf(err, "no padding byte to adjust")
+	}
+
+	adj := body.Clone()
+	for i := 1; i < 0x100; i++ {
+		adj.SecSection[padOff] = body.SecSection[padOff] + byte(i)
+
+		out = msq.EncodeMsqBlock(*adj, gameIdx)
+		if msq.VerifyMsqBlock(out, *adj, blockIdx, true, validate) == nil {
+			return out, adj, nil
+		}
+	}
+
+	return nil, nil, wlerr.Wrapf(err,
+		"no padding value yields a parsable block")
+}
+
+// SerializeGameVerified is like SerializeGame, but it guarantees that the
+// output parses back to the same block bodies when parsed with
+// msq.ParseGameValidate(out, len(dims), validate).  dims is the map dimensions
+// of each map block; the remaining blocks are treated as non-map blocks.
+// validate may be nil.  A map block whose secure section boundary would be
+// misdetected gets its map info padding byte adjusted.  On success, the
+// adjusted bodies are written back to bodies so that the caller's blocks match
+// the output.  It is an error if a block cannot be made to parse correctly.
+func SerializeGameVerified(bodies []msq.Body, gameIdx int, dims []gen.Point,
+	validate msq.BoundaryValidator) ([]byte, error) {
+
+	var out []byte
+	adjs := make([]msq.Body, len(bodies))
+
+	for i, b := range bodies {
+		var dim *gen.Point
+		if i < len(dims) {
+			dim = &dims[i]
+		}
+
+		sub, adj, err := serializeBlockVerified(b, gameIdx, i, dim, validate)
+		if err != nil {
+			return nil, wlerr.Wrapf(err, "block=%d", i)
+		}
+		adjs[i] = *adj
+		out = append(out, sub...)
+	}
+
+	copy(bodies, adjs)
+
+	return out, nil
+}
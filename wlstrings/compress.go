@@ -0,0 +1,165 @@
+package wlstrings
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// CharTableLen is the size, in bytes, of a strings area character table.
+	CharTableLen = 60
+
+	// numDirectChars is the number of character table entries that can be
+	// encoded without a shift code.
+	numDirectChars = StringShiftAmount
+)
+
+// charTablePrefix is the start of every character table.  The MSQ reader
+// relies on these bytes to find the end of a map block's secure section, so
+// they are always placed first, even if no string uses them.
+var charTablePrefix = []byte{0x20, 0x65}
+
+// baseChar converts a character to the character table entry used to encode
+// it.  Capital letters are encoded as their lowercase counterparts preceded
+// by a capital control code.
+func baseChar(c byte) (byte, bool) {
+	if c >= 'A' && c <= 'Z' {
+		return c + 0x20, true
+	}
+
+	return c, false
+}
+
+// BuildCharTable chooses a character table that can encode every character in
+// the given set of plaintext strings.  The most frequently used characters are
+// assigned the entries that do not require a shift code.
+func BuildCharTable(strs [][]byte) ([]byte, error) {
+	counts := map[byte]int{}
+	for _, s := range strs {
+		for _, c := range s {
+			bc, _ := baseChar(c)
+			counts[bc]++
+		}
+	}
+
+	for _, c := range charTablePrefix {
+		delete(counts, c)
+	}
+
+	var chars []byte
+	for c := range counts {
+		chars = append(chars, c)
+	}
+	sort.Slice(chars, func(i int, j int) bool {
+		ci := counts[chars[i]]
+		cj := counts[chars[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return chars[i] < chars[j]
+	})
+
+	table := make([]byte, 0, CharTableLen)
+	table = append(table, charTablePrefix...)
+	table = append(table, chars...)
+
+	if len(table) > CharTableLen {
+		return nil, fmt.Errorf(
+			"too many distinct characters: have=%d want<=%d",
+			len(table), CharTableLen)
+	}
+
+	// Fill unused entries with zeros.
+	table = append(table, make([]byte, CharTableLen-len(table))...)
+
+	return table, nil
+}
+
+// charTableIdx retrieves the index of the first character table entry equal
+// to c.  It returns -1 if there is no such entry.
+func charTableIdx(charTable []byte, c byte) int {
+	for i, tc := range charTable {
+		if tc == c {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// compressChar converts a single character into a sequence of 5-bit codes.
+func compressChar(charTable []byte, c byte) ([]int, error) {
+	var codes []int
+
+	idx := charTableIdx(charTable, c)
+	if idx < 0 {
+		bc, capital := baseChar(c)
+		if capital {
+			idx = charTableIdx(charTable, bc)
+			if idx >= 0 {
+				codes = append(codes, StringCodeCapital)
+			}
+		}
+	}
+
+	if idx < 0 {
+		return nil, fmt.Errorf("character not in table: 0x%02x", c)
+	}
+
+	if idx >= numDirectChars {
+		codes = append(codes, StringCodeShiftChar)
+		idx -= StringShiftAmount
+	}
+
+	return append(codes, idx), nil
+}
+
+// fivebToBytes packs a sequence of 5-bit values into bytes, least significant
+// bit first.  This is the inverse of bitsTo5b.
+func fivebToBytes(vals []int) []byte {
+	numBits := len(vals) * 5
+	b := make([]byte, (numBits+7)/8)
+
+	for i, v := range vals {
+		for j := 0; j < 5; j++ {
+			if v&(1<<uint(j)) != 0 {
+				bit := i*5 + j
+				b[bit/8] |= 1 << uint(bit%8)
+			}
+		}
+	}
+
+	return b
+}
+
+// CompressStringGroup converts ASCII text into a compressed string group.  It
+// is the inverse of DecompressStringGroup.  Every character in data must be
+// present in charTable, either directly or (for capital letters) as its
+// lowercase counterpart.
+func CompressStringGroup(charTable []byte, data []byte) ([]byte, error) {
+	if len(charTable) != CharTableLen {
+		return nil, fmt.Errorf(
+			"character table has invalid length: have=%d want=%d",
+			len(charTable), CharTableLen)
+	}
+
+	var vals []int
+	for i, c := range data {
+		codes, err := compressChar(charTable, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress string: off=%d: %v",
+				i, err)
+		}
+
+		vals = append(vals, codes...)
+	}
+
+	// If the final byte has room for another 5-bit value, the decompressor
+	// will read one.  Fill it with a capital code; a trailing control code
+	// doesn't produce any output.
+	if pad := len(vals) * 5 % 8; pad != 0 && 8-pad >= 5 {
+		vals = append(vals, StringCodeCapital)
+	}
+
+	return fivebToBytes(vals), nil
+}
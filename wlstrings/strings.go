@@ -7,6 +7,9 @@ const (
 	StringCodeShiftChar = 0x1f
 
 	StringShiftAmount = 0x1e
+
+	// StringsPerGroup is the number of strings in a compressed string group.
+	StringsPerGroup = 4
 )
 
 func toBits(data []byte) []bool {
@@ -24,7 +27,7 @@ func toBits(data []byte) []bool {
 
 func bitsTo5b(bits []bool) []int {
 	var vals []int
-	for off := 0; off+5 < len(bits); off += 5 {
+	for off := 0; off+5 <= len(bits); off += 5 {
 		val := 0
 		blob := bits[off : off+5]
 		for i, b := range blob {
@@ -83,3 +86,50 @@ func DecompressStringGroup(charTable []byte, data []byte) ([]byte, error) {
 
 	return raw, nil
 }
+
+// StringEnds finds the end of each string in the compressed string group at
+// the start of data.  It returns the offset, in bytes, of the end of each
+// null-terminated string, stopping after StringsPerGroup strings.  Nothing
+// marks the end of a group, so data may extend beyond it.  If fewer than
+// StringsPerGroup strings can be decoded (the data is truncated, or contains
+// an invalid character index), the ends found so far are returned along with
+// an error.
+func StringEnds(charTable []byte, data []byte) ([]int, error) {
+	fiveb := bitsTo5b(toBits(data))
+
+	var ends []int
+	var shift bool
+
+	for i, v := range fiveb {
+		switch v {
+		case StringCodeCapital:
+
+		case StringCodeShiftChar:
+			shift = true
+
+		default:
+			idx := v
+			if shift {
+				idx += StringShiftAmount
+			}
+			shift = false
+
+			if idx >= len(charTable) {
+				return ends, fmt.Errorf(
+					"invalid character index: have=%d want<%d",
+					idx, len(charTable))
+			}
+
+			if charTable[idx] == 0 {
+				ends = append(ends, ((i+1)*5+7)/8)
+				if len(ends) == StringsPerGroup {
+					return ends, nil
+				}
+			}
+		}
+	}
+
+	return ends, fmt.Errorf(
+		"string group truncated: have=%d strings want=%d",
+		len(ends), StringsPerGroup)
+}
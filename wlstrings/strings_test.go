@@ -1,6 +1,9 @@
 package wlstrings
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 func intsToBools(ints []int) []bool {
 	bools := make([]bool, len(ints))
@@ -74,3 +77,67 @@ func TestTo5b(t *testing.T) {
 		0x1f,
 	})
 }
+
+func TestTo5bAligned(t *testing.T) {
+	// 40 bits contain exactly eight 5-bit values.
+	b := []byte{0xff, 0x00, 0xff, 0x00, 0xff}
+	testTo5bOnce(t, b, []int{
+		0x1f,
+		0x07,
+		0x00,
+		0x1e,
+		0x0f,
+		0x00,
+		0x1c,
+		0x1f,
+	})
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	strs := [][]byte{
+		[]byte("You see a Rusty door.\x00"),
+		[]byte("The Ranger Center is East of here!\x00"),
+		[]byte("xyzzy 1234567890?\x00"),
+	}
+
+	table, err := BuildCharTable(strs)
+	if err != nil {
+		t.Fatalf("failed to build character table: %v", err)
+	}
+	if len(table) != CharTableLen {
+		t.Fatalf("wrong character table length: have=%d want=%d",
+			len(table), CharTableLen)
+	}
+	if table[0] != 0x20 || table[1] != 0x65 {
+		t.Fatalf("character table has wrong prefix: %x", table[:2])
+	}
+
+	for _, s := range strs {
+		for n := 1; n <= len(s); n++ {
+			c, err := CompressStringGroup(table, s[:n])
+			if err != nil {
+				t.Fatalf("failed to compress string: %v", err)
+			}
+
+			d, err := DecompressStringGroup(table, c)
+			if err != nil {
+				t.Fatalf("failed to decompress string: %v", err)
+			}
+
+			if !bytes.Equal(d, s[:n]) {
+				t.Fatalf("round trip mismatch: have=%q want=%q", d, s[:n])
+			}
+		}
+	}
+}
+
+func TestBuildCharTableOverflow(t *testing.T) {
+	var s []byte
+	for c := 0x21; c < 0x80; c++ {
+		s = append(s, byte(c))
+	}
+
+	if _, err := BuildCharTable([][]byte{s}); err == nil {
+		t.Fatalf("oversized character set accepted")
+	}
+}
@@ -1,7 +1,11 @@
 package wlutil
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
+	"reflect"
+	"strings"
 
 	"github.com/badvassal/wllib/decode"
 	"github.com/badvassal/wllib/defs"
@@ -10,6 +14,7 @@ import (
 	"github.com/badvassal/wllib/modify"
 	"github.com/badvassal/wllib/msq"
 	"github.com/badvassal/wllib/serialize"
+	log "github.com/sirupsen/logrus"
 )
 
 func GameIdxToFilename(gameIdx int) (string, error) {
@@ -50,10 +55,22 @@ func ReadGames(inDir string) ([]byte, []byte, error) {
 	return g0, g1, nil
 }
 
+// validateSaveState is a boundary validator for GAME1.  It rejects secure
+// section boundaries that leave the save state block undecodable.
+func validateSaveState(blockIdx int, body msq.Body) bool {
+	if blockIdx != defs.Block0NumBlocks {
+		return true
+	}
+
+	_, err := decode.DecodeSaveState(body)
+	return err == nil
+}
+
 // ParseGames parses the contents of the GAME1 and GAME2 files into sequences
 // of decrypted MSQ blocks.
 func ParseGames(game0 []byte, game1 []byte) ([]msq.Desc, []msq.Desc, error) {
-	d0, err := msq.ParseGame(game0, defs.Block0NumBlocks)
+	d0, err := msq.ParseGameValidate(game0, defs.Block0NumBlocks,
+		validateSaveState)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -98,46 +115,218 @@ func DecodeGame(blocks []msq.Body, gameIdx int) ([]decode.Block, error) {
 	return dbs, nil
 }
 
+// SectionError describes a changed block section that could not be written
+// back to its MSQ block.
+type SectionError struct {
+	GameIdx  int
+	BlockIdx int
+	Section  string
+	Err      error
+}
+
+// CommitError is returned by CommitDecodeState when one or more changed
+// sections could not be committed.
+type CommitError []SectionError
+
+func (ce CommitError) Error() string {
+	var parts []string
+	for _, se := range ce {
+		parts = append(parts, fmt.Sprintf("game=%d block=%d section=%q: %v",
+			se.GameIdx, se.BlockIdx, se.Section, se.Err))
+	}
+
+	return fmt.Sprintf("failed to commit %d section(s): %s",
+		len(ce), strings.Join(parts, "; "))
+}
+
+// blockSection is a section of a decoded block that differs from the
+// original.  queue adds the section's replacement to a transaction.
+type blockSection struct {
+	grows bool // The replacement is larger than the original.
+	queue func(tx *modify.Tx)
+}
+
+// queueChangedSections adds a replacement to tx for every section of db that
+// differs from orig, the block it was decoded from.  The central directory
+// comes first so that the other replacements use the new pointers.  Sections
+// that grow are queued last, after shrinking sections have freed up space.
+func queueChangedSections(tx *modify.Tx, orig *decode.Block, db decode.Block) {
+	var secs []blockSection
+
+	add := func(changed bool, grows bool, queue func(tx *modify.Tx)) {
+		if changed {
+			secs = append(secs, blockSection{grows, queue})
+		}
+	}
+
+	// sizeCmp compares the encoded sizes of an original and a replacement.
+	sizeCmp := func(o []byte, n []byte) (bool, bool) {
+		return !bytes.Equal(o, n), len(n) > len(o)
+	}
+
+	add(!reflect.DeepEqual(orig.CentralDir, db.CentralDir), false,
+		func(tx *modify.Tx) { tx.ReplaceCentralDir(db.CentralDir) })
+
+	for i := 0; i < 16; i++ {
+		i := i
+		changed, grows := sizeCmp(
+			serialize.SerializeActionTable(orig.ActionTables, i, 0),
+			serialize.SerializeActionTable(db.ActionTables, i, 0))
+		add(changed, grows,
+			func(tx *modify.Tx) { tx.ReplaceActionTable(i, db.ActionTables) })
+	}
+
+	// An NPC table that can't be encoded is left for the replacement to
+	// report.
+	onpc, _ := decode.EncodeNPCTable(orig.NPCTable, 0)
+	nnpc, _ := decode.EncodeNPCTable(db.NPCTable, 0)
+	add(!reflect.DeepEqual(orig.NPCTable, db.NPCTable), len(nnpc) > len(onpc),
+		func(tx *modify.Tx) { tx.ReplaceNPCTable(db.NPCTable) })
+
+	changed, grows := sizeCmp(orig.SpecialActions.Actions,
+		db.SpecialActions.Actions)
+	add(changed, grows,
+		func(tx *modify.Tx) { tx.ReplaceSpecialActions(db.SpecialActions) })
+
+	changed, grows = sizeCmp(decode.EncodeMonsterNames(orig.MonsterNames),
+		decode.EncodeMonsterNames(db.MonsterNames))
+	add(changed, grows,
+		func(tx *modify.Tx) { tx.ReplaceMonsterNames(db.MonsterNames) })
+
+	add(!reflect.DeepEqual(orig.MonsterData, db.MonsterData), false,
+		func(tx *modify.Tx) { tx.ReplaceMonsterData(db.MonsterData) })
+
+	add(!reflect.DeepEqual(orig.MapInfo, db.MapInfo), false,
+		func(tx *modify.Tx) { tx.ReplaceMapInfo(db.MapInfo) })
+
+	add(!reflect.DeepEqual(orig.MapData, db.MapData), false,
+		func(tx *modify.Tx) { tx.ReplaceMapData(db.MapData) })
+
+	add(!reflect.DeepEqual(orig.StringsArea, db.StringsArea), false,
+		func(tx *modify.Tx) { tx.ReplaceStringsArea(db.StringsArea) })
+
+	add(!bytes.Equal(orig.Trailer, db.Trailer), false,
+		func(tx *modify.Tx) { tx.ReplaceTrailer(db.Trailer) })
+
+	for _, sec := range secs {
+		if !sec.grows {
+			sec.queue(tx)
+		}
+	}
+	for _, sec := range secs {
+		if sec.grows {
+			sec.queue(tx)
+		}
+	}
+}
+
+// commitBlock writes every changed section of a decoded block to its MSQ
+// block body in a single transaction.  If any section cannot be written, the
+// body is returned unmodified along with every section that failed (without
+// game or block indices).
+func commitBlock(body msq.Body, db decode.Block,
+	dim gen.Point) (msq.Body, []SectionError, error) {
+
+	orig, err := decode.DecodeBlock(body, dim)
+	if err != nil {
+		return body, nil, err
+	}
+
+	m := modify.NewBlockModifier(body, dim)
+	tx := m.Begin()
+	queueChangedSections(tx, orig, db)
+
+	if _, err := tx.Commit(); err != nil {
+		terr, ok := err.(modify.TxError)
+		if !ok {
+			return body, nil, err
+		}
+
+		var serrs []SectionError
+		for _, oe := range terr {
+			serrs = append(serrs, SectionError{
+				Section: oe.Desc,
+				Err:     oe.Err,
+			})
+		}
+		return body, serrs, nil
+	}
+
+	return m.Body(), nil, nil
+}
+
 // CommitDecodeState writes the given decode state to a set of MSQ blocks.
 // After decodede blocks are modified, the modifications are transferred to MSQ
-// blocks with this function.
+// blocks with this function.  Every section that differs from the block it
+// was decoded from is written back.  If some sections cannot be written
+// (e.g., they grew beyond the block's free space), a CommitError listing every
+// failure is returned and none of the blocks are modified.  The save state,
+// if present and modified, replaces the secure section of the first non-map
+// block in GAME1.
 func CommitDecodeState(state decode.DecodeState,
 	bodies1 []msq.Body, bodies2 []msq.Body) error {
 
-	commitGame := func(dbs []decode.Block, bodies []msq.Body,
+	var cerr CommitError
+
+	// Work on copies so that nothing is written unless every block commits.
+	nbodies1 := append([]msq.Body(nil), bodies1...)
+	nbodies2 := append([]msq.Body(nil), bodies2...)
+
+	commitGame := func(gameIdx int, dbs []decode.Block, bodies []msq.Body,
 		dims []gen.Point) error {
 
 		for i, db := range dbs {
-			m := modify.NewBlockModifier(bodies[i], dims[i])
-			if err := m.ReplaceActionTransitions(
-				db.ActionTables.Transitions); err != nil {
-
+			body, serrs, err := commitBlock(bodies[i], db, dims[i])
+			if err != nil {
 				return wlerr.Wrapf(err, "block=%d", i)
 			}
+			bodies[i] = body
 
-			if err := m.ReplaceNPCTable(db.NPCTable); err != nil {
-				return wlerr.Wrapf(err, "block=%d", i)
+			for _, se := range serrs {
+				se.GameIdx = gameIdx
+				se.BlockIdx = i
+				cerr = append(cerr, se)
 			}
-
-			bodies[i] = m.Body()
 		}
 
 		return nil
 	}
 
-	if err := commitGame(state.Blocks[0], bodies1, defs.MapDims[0]); err != nil {
+	if err := commitGame(0, state.Blocks[0], nbodies1, defs.MapDims[0]); err != nil {
 		return err
 	}
 
-	if err := commitGame(state.Blocks[1], bodies2, defs.MapDims[1]); err != nil {
+	if err := commitGame(1, state.Blocks[1], nbodies2, defs.MapDims[1]); err != nil {
 		return err
 	}
 
+	if len(cerr) > 0 {
+		return cerr
+	}
+
+	if state.SaveState != nil && len(nbodies1) > defs.Block0NumBlocks {
+		// Leave the block untouched unless the save state was modified.
+		orig, err := decode.DecodeSaveState(nbodies1[defs.Block0NumBlocks])
+		if err != nil || !reflect.DeepEqual(orig, state.SaveState) {
+			sec, err := decode.EncodeSaveState(*state.SaveState)
+			if err != nil {
+				return err
+			}
+			nbodies1[defs.Block0NumBlocks].SecSection = sec
+		}
+	}
+
+	copy(bodies1, nbodies1)
+	copy(bodies2, nbodies2)
+
 	return nil
 }
 
 // DecodeGames converts a pair of MSQ block sequences (read from the GAME1 and
-// GAME2 files) into a DecodeState.
+// GAME2 files) into a DecodeState.  The first block following GAME1's map
+// blocks is decoded as the save state.  If it cannot be decoded, a warning is
+// logged and the state's SaveState is left nil; the block is then left
+// untouched by CommitDecodeState.
 func DecodeGames(bs1 []msq.Body,
 	bs2 []msq.Body) (*decode.DecodeState, error) {
 
@@ -151,11 +340,21 @@ func DecodeGames(bs1 []msq.Body,
 		return nil, wlerr.Wrapf(err, "game=1")
 	}
 
+	var ss *decode.SaveState
+	if len(bs1) > defs.Block0NumBlocks {
+		ss, err = decode.DecodeSaveState(bs1[defs.Block0NumBlocks])
+		if err != nil {
+			log.Warnf("failed to decode save state; ignoring it: %v", err)
+			ss = nil
+		}
+	}
+
 	return &decode.DecodeState{
-		[][]decode.Block{
+		Blocks: [][]decode.Block{
 			dbs1,
 			dbs2,
 		},
+		SaveState: ss,
 	}, nil
 }
 
@@ -186,12 +385,23 @@ func WriteGames(data0 []byte, data1 []byte, outDir string) error {
 }
 
 // SerailizeAndWriteGames encrypts a pair of MSQ block sequences and writes
-// them to disk as the GAME1 and GAME2.
+// them to disk as the GAME1 and GAME2.  It fails if either file would not
+// parse back (via ParseGames) to the same blocks.  Map blocks whose padding
+// had to be adjusted to parse correctly are updated in place.
 func SerializeAndWriteGames(blocks0 []msq.Body, blocks1 []msq.Body,
 	outDir string) error {
 
-	g0 := serialize.SerializeGame(blocks0, 0)
-	g1 := serialize.SerializeGame(blocks1, 1)
+	g0, err := serialize.SerializeGameVerified(blocks0, 0, defs.MapDims[0],
+		validateSaveState)
+	if err != nil {
+		return wlerr.Wrapf(err, "game=0")
+	}
+
+	g1, err := serialize.SerializeGameVerified(blocks1, 1, defs.MapDims[1],
+		nil)
+	if err != nil {
+		return wlerr.Wrapf(err, "game=1")
+	}
 
 	if err := WriteGames(g0, g1, outDir); err != nil {
 		return err
@@ -210,3 +420,21 @@ func DescsToBodies(descs []msq.Desc) []msq.Body {
 
 	return bodies
 }
+
+// GameFreeSpace calculates the number of reclaimable bytes in each map block
+// of a game.  The result indicates how much a block's areas can grow before a
+// BlockModifier replacement fails.
+func GameFreeSpace(bodies []msq.Body, gameIdx int) ([]int, error) {
+	var frees []int
+
+	for i, dim := range defs.MapDims[gameIdx] {
+		free, err := modify.BlockFreeSpace(bodies[i], dim)
+		if err != nil {
+			return nil, wlerr.Wrapf(err, "block=%d", i)
+		}
+
+		frees = append(frees, free)
+	}
+
+	return frees, nil
+}
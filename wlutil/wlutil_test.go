@@ -0,0 +1,76 @@
+package wlutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/internal/blocktest"
+	"github.com/badvassal/wllib/serialize"
+)
+
+func TestCommitBlock(t *testing.T) {
+	b := blocktest.Block()
+	dim := b.Dim
+
+	loot := &action.Loot{}
+	for i := 0; i < 8; i++ {
+		loot.Items = append(loot.Items, action.LootItem{ID: i + 1, Amount: 1})
+	}
+	b.ActionTables.Loots = []*action.Loot{loot}
+
+	body, err := serialize.EncodeBlock(b, 0)
+	if err != nil {
+		t.Fatalf("failed to encode block: %v", err)
+	}
+
+	db, err := decode.DecodeBlock(*body, dim)
+	if err != nil {
+		t.Fatalf("failed to decode block: %v", err)
+	}
+
+	// The print table (1) only fits once the loot table (5) has shrunk.
+	db.MapInfo.MaxEncounters = 3
+	db.MonsterData.Monsters[0].HitPoints = 9
+	db.ActionTables.Loots[0].Items = db.ActionTables.Loots[0].Items[:1]
+	db.ActionTables.Prints = []*action.Print{
+		&action.Print{ToClass: 0xff, StringIDs: []int{1}},
+	}
+
+	nbody, serrs, err := commitBlock(*body, *db, dim)
+	if err != nil {
+		t.Fatalf("failed to commit block: %v", err)
+	}
+	if len(serrs) != 0 {
+		t.Fatalf("unexpected section errors: %v", CommitError(serrs))
+	}
+
+	nb, err := decode.DecodeBlock(nbody, dim)
+	if err != nil {
+		t.Fatalf("failed to decode committed block: %v", err)
+	}
+	if nb.MapInfo.MaxEncounters != 3 ||
+		nb.MonsterData.Monsters[0].HitPoints != 9 ||
+		len(nb.ActionTables.Loots[0].Items) != 1 ||
+		len(nb.ActionTables.Prints) != 1 {
+
+		t.Fatalf("changes not committed: %+v", nb)
+	}
+
+	// A monster data section of a different size can't be written, so none
+	// of the changes may be committed.
+	nb.MonsterData.Monsters = nil
+	nb.MapInfo.MaxEncounters = 4
+
+	fbody, serrs, err := commitBlock(nbody, *nb, dim)
+	if err != nil {
+		t.Fatalf("failed to commit block: %v", err)
+	}
+	if len(serrs) != 1 || serrs[0].Section != "monster data" {
+		t.Fatalf("unexpected section errors: %+v", serrs)
+	}
+	if !bytes.Equal(fbody.SecSection, nbody.SecSection) {
+		t.Fatalf("failed commit modified block")
+	}
+}